@@ -0,0 +1,95 @@
+package blockarchiver
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultBackoffBase is the delay before the first retry of the default backoff strategy
+	DefaultBackoffBase = 200 * time.Millisecond
+	// DefaultBackoffMax is the upper bound of a single delay of the default backoff strategy
+	DefaultBackoffMax = 10 * time.Second
+)
+
+// BackoffStrategy decides how long to wait before retrying a failed request
+type BackoffStrategy interface {
+	// NextDelay returns the delay before the given retry attempt, attempts start from 1
+	NextDelay(attempt int) time.Duration
+}
+
+// DefaultBackoff returns the backoff strategy used by the client when none is configured
+func DefaultBackoff() BackoffStrategy {
+	return &ExponentialBackoff{Base: DefaultBackoffBase, Max: DefaultBackoffMax, Jitter: true}
+}
+
+// ConstantBackoff waits the same delay before every attempt
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// NextDelay implements BackoffStrategy
+func (b *ConstantBackoff) NextDelay(attempt int) time.Duration {
+	return b.Delay
+}
+
+// ExponentialBackoff doubles the delay on every attempt, starting from Base and capped at Max.
+// With Jitter enabled, the delay is randomized within the upper half of the computed value so
+// that concurrent retries spread out.
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter bool
+}
+
+// NextDelay implements BackoffStrategy
+func (b *ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := b.Base
+	for i := 1; i < attempt && (b.Max <= 0 || delay < b.Max); i++ {
+		delay = saturatingMul(delay, 2)
+	}
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	if b.Jitter && delay > 1 {
+		half := delay / 2
+		delay = half + time.Duration(rand.Int63n(int64(delay-half)))
+	}
+	return delay
+}
+
+// DecorrelatedJitterBackoff picks a random delay between Base and three times the largest delay
+// the previous attempt could have used, capped at Max. It trades predictability for a better
+// spread of retries coming from many clients at once.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// NextDelay implements BackoffStrategy
+func (b *DecorrelatedJitterBackoff) NextDelay(attempt int) time.Duration {
+	upper := b.Base
+	for i := 1; i < attempt && (b.Max <= 0 || upper < b.Max); i++ {
+		upper = saturatingMul(upper, 3)
+	}
+	if b.Max > 0 && upper > b.Max {
+		upper = b.Max
+	}
+	if upper <= b.Base {
+		return upper
+	}
+	return b.Base + time.Duration(rand.Int63n(int64(upper-b.Base)))
+}
+
+// saturatingMul multiplies a delay by factor, saturating at the largest representable duration
+// instead of overflowing when the strategy is uncapped
+func saturatingMul(delay time.Duration, factor int64) time.Duration {
+	if delay > time.Duration(math.MaxInt64/factor) {
+		return time.Duration(math.MaxInt64)
+	}
+	return delay * time.Duration(factor)
+}
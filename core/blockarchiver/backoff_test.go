@@ -0,0 +1,88 @@
+package blockarchiver
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := &ConstantBackoff{Delay: time.Second}
+	for attempt := 1; attempt <= 5; attempt++ {
+		if delay := b.NextDelay(attempt); delay != time.Second {
+			t.Errorf("attempt %d: want %v, got %v", attempt, time.Second, delay)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{Base: 100 * time.Millisecond, Max: time.Second}
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, w := range want {
+		if delay := b.NextDelay(i + 1); delay != w {
+			t.Errorf("attempt %d: want %v, got %v", i+1, w, delay)
+		}
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	b := &ExponentialBackoff{Base: 100 * time.Millisecond, Max: time.Second, Jitter: true}
+	plain := &ExponentialBackoff{Base: b.Base, Max: b.Max}
+	for attempt := 1; attempt <= 6; attempt++ {
+		upper := plain.NextDelay(attempt)
+		for i := 0; i < 100; i++ {
+			delay := b.NextDelay(attempt)
+			if delay < upper/2 || delay >= upper {
+				t.Fatalf("attempt %d: delay %v out of range [%v, %v)", attempt, delay, upper/2, upper)
+			}
+		}
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	b := &DecorrelatedJitterBackoff{Base: 100 * time.Millisecond, Max: 2 * time.Second}
+	if delay := b.NextDelay(1); delay != b.Base {
+		t.Errorf("first attempt: want %v, got %v", b.Base, delay)
+	}
+	uppers := []time.Duration{300 * time.Millisecond, 900 * time.Millisecond, 2 * time.Second, 2 * time.Second}
+	for i, upper := range uppers {
+		attempt := i + 2
+		for j := 0; j < 100; j++ {
+			delay := b.NextDelay(attempt)
+			if delay < b.Base || delay >= upper {
+				t.Fatalf("attempt %d: delay %v out of range [%v, %v)", attempt, delay, b.Base, upper)
+			}
+		}
+	}
+}
+
+func TestUncappedBackoffSaturates(t *testing.T) {
+	strategies := []BackoffStrategy{
+		&ExponentialBackoff{Base: 200 * time.Millisecond},
+		&ExponentialBackoff{Base: 200 * time.Millisecond, Jitter: true},
+		&DecorrelatedJitterBackoff{Base: 200 * time.Millisecond},
+	}
+	for _, b := range strategies {
+		prev := time.Duration(0)
+		for _, attempt := range []int{1, 10, 36, 37, 38, 64, 100, 1000} {
+			delay := b.NextDelay(attempt)
+			if delay <= 0 {
+				t.Fatalf("%T attempt %d: non-positive delay %v", b, attempt, delay)
+			}
+			if _, jitter := b.(*DecorrelatedJitterBackoff); !jitter && delay < prev/2 {
+				t.Fatalf("%T attempt %d: delay %v shrank from %v", b, attempt, delay, prev)
+			}
+			prev = delay
+		}
+	}
+	if delay := (&ExponentialBackoff{Base: 200 * time.Millisecond}).NextDelay(1000); delay != math.MaxInt64 {
+		t.Errorf("want saturated delay, got %v", delay)
+	}
+}
@@ -0,0 +1,294 @@
+package blockarchiver
+
+import (
+	"math"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// withdrawalSize is the memory held by a decoded withdrawal
+const withdrawalSize = uint64(unsafe.Sizeof(types.Withdrawal{}))
+
+// bodySize estimates the memory held by a decoded body
+func bodySize(body *types.Body) uint64 {
+	var size uint64
+	for _, tx := range body.Transactions {
+		size += tx.Size()
+	}
+	return size + uint64(len(body.Withdrawals))*withdrawalSize
+}
+
+// bodyBudget bounds the memory held by the body cache, which is otherwise sized by entry count.
+// It tracks the size of every body inserted by the service and evicts the oldest ones once the
+// total exceeds the limit. Bodies dropped by the count limit of the cache itself are accounted
+// for until they are the oldest tracked entries, which makes the budget err on the safe side.
+type bodyBudget struct {
+	cache *lru.Cache[common.Hash, *types.Body]
+	limit uint64
+
+	mu    sync.Mutex
+	used  uint64
+	sizes lru.BasicLRU[common.Hash, uint64]
+}
+
+func newBodyBudget(cache *lru.Cache[common.Hash, *types.Body], limit uint64) *bodyBudget {
+	return &bodyBudget{
+		cache: cache,
+		limit: limit,
+		sizes: lru.NewBasicLRU[common.Hash, uint64](math.MaxInt32),
+	}
+}
+
+// add inserts a body into the cache and evicts the oldest bodies if the budget is exceeded
+func (b *bodyBudget) add(hash common.Hash, body *types.Body) {
+	size := bodySize(body)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cache.Add(hash, body)
+	if old, ok := b.sizes.Peek(hash); ok {
+		b.used -= old
+	}
+	b.sizes.Add(hash, size)
+	b.used += size
+	for b.used > b.limit {
+		oldest, oldSize, ok := b.sizes.RemoveOldest()
+		if !ok {
+			break
+		}
+		b.used -= oldSize
+		b.cache.Remove(oldest)
+	}
+	bodyCacheBytesGauge.Update(int64(b.used))
+}
+
+// cacheSizeSamples is the number of fetched bundles the cache sizes are checked against
+const cacheSizeSamples = 4
+
+// cacheSizeCheck detects caches too small to hold a bundle, where every bundle fetch evicts the
+// blocks it has just inserted. Only the first few bundles are checked, the bundle size is
+// stable, and every undersized cache is only reported once.
+type cacheSizeCheck struct {
+	mu      sync.Mutex
+	samples int
+	warned  map[string]bool
+}
+
+// observe checks a freshly populated bundle against the number of entries of the caches. Once
+// a bundle of n distinct blocks is inserted, a cache holding fewer than n entries can't fit it.
+func (s *cacheSizeCheck) observe(bundleSize int, lens map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples >= cacheSizeSamples {
+		return
+	}
+	s.samples++
+	if s.warned == nil {
+		s.warned = make(map[string]bool)
+	}
+	for _, name := range []string{"hash", "header", "body"} {
+		entries, ok := lens[name]
+		if !ok || entries >= bundleSize || s.warned[name] {
+			continue
+		}
+		s.warned[name] = true
+		undersizedCacheCounter.Inc(1)
+		log.Warn("block archiver cache is smaller than a bundle, consider increasing the block cache size",
+			"cache", name, "entries", entries, "bundle", bundleSize)
+	}
+}
+
+// adaptiveWindow is the number of lookups the adaptive capacity is re-evaluated after
+const adaptiveWindow = 1024
+
+// adaptiveCapacity sizes the block caches to the working set, between the configured block cache
+// size and an upper bound. It tracks the full blocks cached by the service, evicting the oldest ones
+// from the caches once the capacity is reached. The capacity doubles after a window of lookups
+// with a low hit rate and a high eviction rate, i.e. when the cache is thrashing, and halves
+// when the blocks looked up during a window would fit in a quarter of it.
+type adaptiveCapacity struct {
+	min, max int
+	// evict drops a block from the caches once it falls out of the capacity
+	evict func(number uint64, hash common.Hash)
+
+	mu       sync.Mutex
+	capacity int
+	blocks   lru.BasicLRU[uint64, common.Hash]
+	// statistics of the current window
+	hits, misses, evictions int
+	seen                    map[uint64]struct{}
+}
+
+func newAdaptiveCapacity(min, max int, evict func(number uint64, hash common.Hash)) *adaptiveCapacity {
+	cacheCapacityGauge.Update(int64(min))
+	return &adaptiveCapacity{
+		min:      min,
+		max:      max,
+		evict:    evict,
+		capacity: min,
+		blocks:   lru.NewBasicLRU[uint64, common.Hash](math.MaxInt32),
+		seen:     make(map[uint64]struct{}),
+	}
+}
+
+// add tracks a block inserted into the caches, evicting the oldest ones beyond the capacity
+func (a *adaptiveCapacity) add(number uint64, hash common.Hash) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.blocks.Add(number, hash)
+	a.shrinkTo(a.capacity)
+}
+
+// lookup accounts a block lookup and adapts the capacity at the end of every window
+func (a *adaptiveCapacity) lookup(number uint64, hit bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if hit {
+		a.hits++
+		a.blocks.Get(number)
+	} else {
+		a.misses++
+	}
+	if len(a.seen) < adaptiveWindow {
+		a.seen[number] = struct{}{}
+	}
+	if a.hits+a.misses < adaptiveWindow {
+		return
+	}
+	lookups := a.hits + a.misses
+	switch {
+	case a.hits*2 < lookups && a.evictions*4 >= lookups && a.capacity < a.max:
+		a.capacity = a.capacity * 2
+		if a.capacity > a.max {
+			a.capacity = a.max
+		}
+		log.Info("growing block archiver cache", "capacity", a.capacity)
+	case len(a.seen)*4 <= a.capacity && a.capacity > a.min:
+		a.capacity = a.capacity / 2
+		if a.capacity < a.min {
+			a.capacity = a.min
+		}
+		log.Info("shrinking block archiver cache", "capacity", a.capacity)
+		a.shrinkTo(a.capacity)
+	}
+	cacheCapacityGauge.Update(int64(a.capacity))
+	a.hits, a.misses, a.evictions = 0, 0, 0
+	a.seen = make(map[uint64]struct{})
+}
+
+// shrinkTo evicts the oldest blocks until at most n are tracked
+func (a *adaptiveCapacity) shrinkTo(n int) {
+	for a.blocks.Len() > n {
+		number, hash, ok := a.blocks.RemoveOldest()
+		if !ok {
+			return
+		}
+		a.evictions++
+		a.evict(number, hash)
+	}
+}
+
+// bundleResults keeps the blocks of the recently fetched bundles by bundle name for a short
+// while, so a request for a bundle whose fetch just completed, e.g. a straggler which claimed its
+// range right after the fetch or whose blocks were already evicted, reuses them without
+// downloading the bundle again
+type bundleResults struct {
+	ttl   time.Duration
+	clock mclock.Clock
+
+	mu      sync.Mutex
+	results map[string]bundleResult
+}
+
+type bundleResult struct {
+	blocks  []*Block
+	expires mclock.AbsTime
+}
+
+func newBundleResults(ttl time.Duration, clock mclock.Clock) *bundleResults {
+	return &bundleResults{ttl: ttl, clock: clock, results: make(map[string]bundleResult)}
+}
+
+// get returns the blocks of the bundle if it was fetched less than the ttl ago
+func (r *bundleResults) get(name string) ([]*Block, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result, ok := r.results[name]
+	if !ok || r.clock.Now() > result.expires {
+		return nil, false
+	}
+	return result.blocks, true
+}
+
+// add keeps the blocks of a fetched bundle, dropping the expired results
+func (r *bundleResults) add(name string, blocks []*Block) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	for n, result := range r.results {
+		if now > result.expires {
+			delete(r.results, n)
+		}
+	}
+	r.results[name] = bundleResult{blocks: blocks, expires: now.Add(r.ttl)}
+}
+
+// missingBlocks remembers the block numbers the archiver recently failed to serve for a short
+// while, so repeated requests for a block which isn't archived yet fail fast instead of asking
+// the archiver again. An entry is dropped as soon as the block is cached.
+type missingBlocks struct {
+	ttl   time.Duration
+	clock mclock.Clock
+
+	mu      sync.Mutex
+	entries map[uint64]missingBlock
+}
+
+type missingBlock struct {
+	err     error
+	expires mclock.AbsTime
+}
+
+func newMissingBlocks(ttl time.Duration, clock mclock.Clock) *missingBlocks {
+	return &missingBlocks{ttl: ttl, clock: clock, entries: make(map[uint64]missingBlock)}
+}
+
+// get returns the error the block failed with if it was missing less than the ttl ago, nil
+// otherwise
+func (m *missingBlocks) get(number uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[number]
+	if !ok || m.clock.Now() > entry.expires {
+		return nil
+	}
+	return entry.err
+}
+
+// add remembers a missing block, dropping the expired entries
+func (m *missingBlocks) add(number uint64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	for n, entry := range m.entries {
+		if now > entry.expires {
+			delete(m.entries, n)
+		}
+	}
+	m.entries[number] = missingBlock{err: err, expires: now.Add(m.ttl)}
+}
+
+// remove forgets a missing block once it is available
+func (m *missingBlocks) remove(number uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, number)
+}
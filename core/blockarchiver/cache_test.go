@@ -0,0 +1,93 @@
+package blockarchiver
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestBodyBudget(t *testing.T) {
+	body := &types.Body{Transactions: newTestTransactions(t)}
+	size := bodySize(body)
+	if size == 0 {
+		t.Fatal("want non-zero body size")
+	}
+	cache := lru.NewCache[common.Hash, *types.Body](100)
+	budget := newBodyBudget(cache, 2*size)
+
+	hashes := []common.Hash{{0x01}, {0x02}, {0x03}}
+	for _, hash := range hashes {
+		budget.add(hash, body)
+	}
+	if cache.Contains(hashes[0]) {
+		t.Error("oldest body not evicted when the budget was exceeded")
+	}
+	for _, hash := range hashes[1:] {
+		if !cache.Contains(hash) {
+			t.Errorf("body %x evicted within the budget", hash)
+		}
+	}
+	if budget.used != 2*size {
+		t.Errorf("want %d bytes used, got %d", 2*size, budget.used)
+	}
+	// re-adding a tracked body must not account it twice
+	budget.add(hashes[2], body)
+	if budget.used != 2*size || cache.Len() != 2 {
+		t.Errorf("re-added body accounted twice, used %d, cached %d", budget.used, cache.Len())
+	}
+}
+
+func TestCacheSizeCheck(t *testing.T) {
+	var check cacheSizeCheck
+	check.observe(100, map[string]int{"hash": 100, "header": 150, "body": 60})
+	if !check.warned["body"] || check.warned["hash"] || check.warned["header"] {
+		t.Fatalf("want only the body cache reported, got %v", check.warned)
+	}
+	for i := 1; i < cacheSizeSamples; i++ {
+		check.observe(100, map[string]int{"hash": 100, "header": 150, "body": 60})
+	}
+	// bundles past the first samples are not checked anymore
+	check.observe(200, map[string]int{"hash": 100, "header": 150, "body": 60})
+	if check.warned["hash"] || check.warned["header"] {
+		t.Errorf("want no check past %d bundles, got %v", cacheSizeSamples, check.warned)
+	}
+	if check.samples != cacheSizeSamples {
+		t.Errorf("want %d samples, got %d", cacheSizeSamples, check.samples)
+	}
+}
+
+func TestAdaptiveCapacity(t *testing.T) {
+	evicted := make(map[uint64]bool)
+	adaptive := newAdaptiveCapacity(100, 400, func(number uint64, hash common.Hash) { evicted[number] = true })
+
+	// a working set of 300 blocks thrashes a cache of 100 entries, the capacity grows to fit it
+	cached := func(number uint64) bool { return adaptive.blocks.Contains(number) }
+	for round := 0; round < 8; round++ {
+		for number := uint64(0); number < 300; number++ {
+			hit := cached(number)
+			adaptive.lookup(number, hit)
+			if !hit {
+				adaptive.add(number, common.Hash{byte(number)})
+			}
+		}
+	}
+	if adaptive.capacity != 400 {
+		t.Errorf("want capacity grown to 400, got %d", adaptive.capacity)
+	}
+	if len(evicted) == 0 {
+		t.Error("want evictions while the cache was too small")
+	}
+
+	// a working set of 10 blocks shrinks the capacity back to the minimum
+	for i := 0; i < 4*adaptiveWindow; i++ {
+		adaptive.lookup(uint64(i%10), true)
+	}
+	if adaptive.capacity != 100 {
+		t.Errorf("want capacity shrunk to 100, got %d", adaptive.capacity)
+	}
+	if n := adaptive.blocks.Len(); n > 100 {
+		t.Errorf("want at most 100 tracked blocks after shrinking, got %d", n)
+	}
+}
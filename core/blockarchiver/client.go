@@ -7,142 +7,949 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
 
 	bundlesdk "github.com/bnb-chain/greenfield-bundle-sdk/bundle"
 )
 
+// maxErrorSnippet is the number of body bytes quoted in errors about malformed responses
+const maxErrorSnippet = 256
+
+// headerBatchSize is the number of headers requested in a single JSON-RPC batch
+const headerBatchSize = 100
+
+// blockBatchSize is the number of full blocks requested in a single JSON-RPC batch
+const blockBatchSize = 20
+
+// DefaultMaxBlockRange bounds the number of blocks requested by a single GetBlocksByRange call
+const DefaultMaxBlockRange = 1000
+
+// DefaultMaxResponseBytes bounds the size of a response body, bundles included
+const DefaultMaxResponseBytes = 1 << 30
+
+// DefaultDialTimeout bounds the connection establishment to the block archiver
+const DefaultDialTimeout = 10 * time.Second
+
+const (
+	// DefaultHTTPTimeout bounds a whole HTTP exchange, response body included
+	DefaultHTTPTimeout = 10 * time.Minute
+	// DefaultMaxConnsPerHost bounds the connections, idle or not, kept to a single host
+	DefaultMaxConnsPerHost = 1000
+	// DefaultIdleConnTimeout is how long an idle connection is kept before being closed
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds the configured limit
+var ErrResponseTooLarge = errors.New("block archiver response too large")
+
+// ErrArchiverUnavailable is matched by the errors of the requests which failed on the network, with
+// a server error or were rate limited, once the retries and the failover hosts are exhausted. The
+// underlying error stays matchable, e.g. a *StatusError or a *RateLimitError.
+var ErrArchiverUnavailable = errors.New("block archiver unavailable")
+
+// ErrDecodeTimeout is returned when decoding a bundle takes longer than the decode timeout
+var ErrDecodeTimeout = errors.New("bundle decode timed out")
+
+// StatusError is returned when the block archiver answers with an unexpected HTTP status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to get response: status %d", e.StatusCode)
+}
+
+// errNotFound is returned by REST requests answered with a 404, e.g. by archivers without the endpoint
+var errNotFound = errors.New("block archiver endpoint not found")
+
+// maxBundlePages bounds the number of pages followed for a single paginated bundle
+const maxBundlePages = 1000
+
 // Client is a client to interact with the block archiver service
 type Client struct {
 	hc                *http.Client
 	blockArchiverHost string
 	spHost            string
 	bucketName        string
+	// rpc is the JSON-RPC endpoint at blockArchiverHost, rest the REST endpoint under the same host
+	// serving the bundle names
+	rpc  *endpoint
+	rest *endpoint
+	// maxResponseBytes bounds the size of a response body once decompressed
+	maxResponseBytes int64
+	// maxBlockRange bounds the number of blocks of a GetBlocksByRange call
+	maxBlockRange uint64
+	// compression asks for gzip encoded responses, see WithCompression
+	compression bool
+	// dialTimeout bounds the connection establishment, independently of the request timeout
+	dialTimeout time.Duration
+	// httpTimeout, maxConnsPerHost and idleConnTimeout configure the HTTP client and its pool
+	httpTimeout     time.Duration
+	maxConnsPerHost int
+	idleConnTimeout time.Duration
+	// warmUpTimeout bounds the warm-up done in New, zero disables the warm-up
+	warmUpTimeout time.Duration
+	// decodeTimeout bounds the decoding of a downloaded bundle, zero disables the bound
+	decodeTimeout time.Duration
+	// methods names the JSON-RPC methods called on the block archiver
+	methods RPCMethods
+	// methodTimeouts bounds the calls of specific methods by name, see WithMethodTimeouts
+	methodTimeouts map[string]time.Duration
+
+	// tenants is the allowlist of tenant tags recorded in the request logs and metrics
+	tenants map[string]struct{}
+	// clock drives the retry backoff and the time based logic of the service using the client
+	clock mclock.Clock
+	// retries is the default number of retries of a failed JSON-RPC request, see WithRetries
+	retries int
+	// retryMaxElapsed bounds the time spent retrying a request, zero for no bound
+	retryMaxElapsed time.Duration
+	// customHTTPClient is set when hc was supplied by the caller, see WithHTTPClient
+	customHTTPClient bool
+	// diagnosticHeaders are the response headers logged for every request, nil to capture none
+	diagnosticHeaders []string
+	// observer is notified of every request, see WithObserver
+	observer Observer
+	// ring spreads the requests over several hosts by bundle, nil to only use blockArchiverHost
+	ring *hostRing
+
+	closeOnce sync.Once
+}
+
+// Option configures optional behaviours of the Client
+type Option func(*Client)
+
+// WithBackoff sets the backoff strategy used between retries by both endpoints, the default is
+// an exponential backoff with jitter. Only the JSON-RPC requests are retried, see WithRetries.
+// See WithRPCPolicy and WithRESTPolicy to set a strategy per endpoint.
+func WithBackoff(backoff BackoffStrategy) Option {
+	return func(c *Client) {
+		c.rpc.backoff = backoff
+		c.rest.backoff = backoff
+	}
+}
+
+// WithMaxResponseBytes bounds the size of the response bodies, DefaultMaxResponseBytes by
+// default. The limit applies to the decoded body, so a small compressed payload inflating to
+// gigabytes is cut off as soon as it crosses the limit.
+func WithMaxResponseBytes(limit int64) Option {
+	return func(c *Client) {
+		c.maxResponseBytes = limit
+	}
+}
+
+// WithMaxBlockRange bounds the number of blocks a GetBlocksByRange call may request,
+// DefaultMaxBlockRange by default, so a wrong range can't request millions of blocks
+func WithMaxBlockRange(blocks uint64) Option {
+	return func(c *Client) {
+		c.maxBlockRange = blocks
+	}
+}
+
+// WithCompression lets the transport ask for gzip encoded responses and decode them
+// transparently, which cuts the transfer of the large bundles against a remote archiver at the
+// cost of some CPU. Compression is disabled by default.
+func WithCompression(enabled bool) Option {
+	return func(c *Client) {
+		c.compression = enabled
+	}
+}
+
+// WithDialTimeout bounds the time spent connecting to the block archiver, DefaultDialTimeout by
+// default, so an unreachable archiver fails fast instead of holding the request until it times out
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithWarmUp makes New pre-dial the block archiver and the storage provider, so the first
+// real request doesn't pay for the TCP and TLS handshakes. A failed warm-up is only logged.
+func WithWarmUp(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.warmUpTimeout = timeout
+	}
+}
+
+// WithDecodeTimeout bounds the time spent decoding a downloaded bundle into blocks, so a huge
+// bundle can't hold a fetch slot for long. The download itself isn't covered, it is bounded by
+// the request context. A bundle decoding for longer fails with ErrDecodeTimeout.
+func WithDecodeTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.decodeTimeout = timeout
+	}
+}
+
+// WithTimeout bounds every HTTP exchange with the block archiver and the storage provider,
+// DefaultHTTPTimeout by default. It applies on top of the contexts of the calls, a lower value
+// suits latency sensitive callers talking to a local archiver.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpTimeout = timeout
+	}
 }
 
-func New(blockAchieverHost, spHost, bucketName string) (*Client, error) {
-	transport := &http.Transport{
-		DisableCompression:  true,
-		MaxIdleConnsPerHost: 1000,
-		MaxConnsPerHost:     1000,
-		IdleConnTimeout:     90 * time.Second,
+// WithMaxConnsPerHost bounds the connections kept to each host, both the active and the idle
+// ones, DefaultMaxConnsPerHost by default
+func WithMaxConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.maxConnsPerHost = n
 	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept open, DefaultIdleConnTimeout by
+// default
+func WithIdleConnTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.idleConnTimeout = timeout
+	}
+}
+
+// WithHTTPClient makes the client send its requests with hc, e.g. to share a process-wide
+// instrumented transport or to go through a proxy with custom TLS roots. The client is used as
+// is: WithTimeout, WithDialTimeout and the connection pool options don't apply to it, and Close
+// leaves its connections to the caller.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+		c.customHTTPClient = true
+	}
+}
+
+func New(blockAchieverHost, spHost, bucketName string, opts ...Option) (*Client, error) {
+	// the pool settings and the timeouts are set once the options are applied
+	transport := &http.Transport{}
 	client := &http.Client{
-		Timeout:   10 * time.Minute,
 		Transport: transport,
 	}
-	return &Client{hc: client, blockArchiverHost: blockAchieverHost, spHost: spHost, bucketName: bucketName}, nil
+	c := &Client{
+		hc:                client,
+		blockArchiverHost: blockAchieverHost,
+		spHost:            spHost,
+		bucketName:        bucketName,
+		rpc:               newEndpoint("rpc"),
+		rest:              newEndpoint("rest"),
+		maxResponseBytes:  DefaultMaxResponseBytes,
+		maxBlockRange:     DefaultMaxBlockRange,
+		dialTimeout:       DefaultDialTimeout,
+		httpTimeout:       DefaultHTTPTimeout,
+		maxConnsPerHost:   DefaultMaxConnsPerHost,
+		idleConnTimeout:   DefaultIdleConnTimeout,
+		methods:           DefaultRPCMethods,
+		observer:          noopObserver{},
+		clock:             mclock.System{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.methods.validate(); err != nil {
+		return nil, err
+	}
+	if !c.customHTTPClient {
+		transport.DialContext = (&net.Dialer{Timeout: c.dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.MaxIdleConnsPerHost = c.maxConnsPerHost
+		transport.MaxConnsPerHost = c.maxConnsPerHost
+		transport.IdleConnTimeout = c.idleConnTimeout
+		transport.DisableCompression = !c.compression
+		client.Timeout = c.httpTimeout
+	}
+	if c.warmUpTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.warmUpTimeout)
+		defer cancel()
+		if err := c.WarmUp(ctx); err != nil {
+			log.Warn("failed to warm up block archiver connections", "err", err)
+		}
+	}
+	return c, nil
+}
+
+// Close releases the idle connections held by the client, unless its HTTP client was supplied
+// with WithHTTPClient. It is safe to call Close several
+// times and concurrently.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		if !c.customHTTPClient {
+			c.hc.CloseIdleConnections()
+		}
+	})
+	return nil
+}
+
+// WarmUp primes the connection pool by sending a HEAD request to the block archiver and the
+// storage provider. Any response, whatever its status, leaves an established connection behind.
+func (c *Client) WarmUp(ctx context.Context) error {
+	hosts := []string{c.blockArchiverHost}
+	if c.ring != nil {
+		hosts = c.ring.hosts
+	}
+	if strings.Contains(c.spHost, "//") {
+		hosts = append(hosts, c.bundleURL(""))
+	}
+	for _, host := range hosts {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, host, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.hc.Do(req)
+		if err != nil {
+			return err
+		}
+		// drain the body so the connection goes back to the idle pool
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// ChainID returns the chain id reported by the block archiver, e.g. 56 for BSC mainnet and 97
+// for the testnet
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	ctx, done := c.methodContext(ctx, "ChainID")
+	defer done()
+	payload := preparePayload(c.methods.ChainID, []interface{}{})
+	body, err := c.postRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	getChainIDResp := GetChainIDResponse{}
+	err = unmarshalJSON(body, &getChainIDResp)
+	if err != nil {
+		return nil, err
+	}
+	if getChainIDResp.Error != nil {
+		return nil, getChainIDResp.Error
+	}
+	if !strings.HasPrefix(getChainIDResp.Result, "0x") {
+		return nil, fmt.Errorf("invalid chain id %q", getChainIDResp.Result)
+	}
+	chainID, err := HexToBigInt(getChainIDResp.Result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain id %q: %w", getChainIDResp.Result, err)
+	}
+	return chainID, nil
+}
+
+// Ping checks that the block archiver is reachable and answers JSON-RPC requests with a cheap
+// eth_chainId call, so a misconfigured host can be reported at startup rather than on the first
+// block fetch
+func (c *Client) Ping(ctx context.Context) error {
+	if _, err := c.ChainID(ctx); err != nil {
+		return fmt.Errorf("block archiver %s unreachable: %w", c.blockArchiverHost, err)
+	}
+	return nil
 }
 
 func (c *Client) GetBlockByHash(ctx context.Context, hash common.Hash) (*Block, error) {
-	payload := preparePayload("eth_getBlockByHash", []interface{}{hash.String(), "true"})
+	ctx, done := c.methodContext(ctx, "GetBlockByHash")
+	defer done()
+	payload := preparePayload(c.methods.GetBlockByHash, []interface{}{hash.String(), "true"})
 	body, err := c.postRequest(ctx, payload)
 	if err != nil {
 		return nil, err
 	}
 	getBlockResp := GetBlockResponse{}
-	err = json.Unmarshal(body, &getBlockResp)
+	err = unmarshalJSON(body, &getBlockResp)
 	if err != nil {
 		return nil, err
 	}
+	if getBlockResp.Error != nil {
+		return nil, getBlockResp.Error
+	}
 	return getBlockResp.Result, nil
 }
 
+// GetRawTransactionByHash returns the transaction by hash as served by the block archiver, with
+// every field of its JSON representation, nil if the transaction is unknown. Use the service to
+// get transactions converted to go-ethereum types.
+func (c *Client) GetRawTransactionByHash(ctx context.Context, hash common.Hash) (*Transaction, error) {
+	ctx, done := c.methodContext(ctx, "GetRawTransactionByHash")
+	defer done()
+	payload := preparePayload(c.methods.GetTransactionByHash, []interface{}{hash.String()})
+	body, err := c.postRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	getTransactionResp := GetTransactionResponse{}
+	err = unmarshalJSON(body, &getTransactionResp)
+	if err != nil {
+		return nil, err
+	}
+	if getTransactionResp.Error != nil {
+		return nil, getTransactionResp.Error
+	}
+	return getTransactionResp.Result, nil
+}
+
+// GetTransactionReceipt returns the receipt of the transaction by hash, nil if the transaction is
+// unknown
+func (c *Client) GetTransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	ctx, done := c.methodContext(ctx, "GetTransactionReceipt")
+	defer done()
+	payload := preparePayload(c.methods.GetTransactionReceipt, []interface{}{hash.String()})
+	body, err := c.postRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	getReceiptResp := GetTransactionReceiptResponse{}
+	err = unmarshalJSON(body, &getReceiptResp)
+	if err != nil {
+		return nil, err
+	}
+	if getReceiptResp.Error != nil {
+		return nil, getReceiptResp.Error
+	}
+	if getReceiptResp.Result == nil {
+		return nil, nil
+	}
+	receipts, err := convertReceipts([]*Receipt{getReceiptResp.Result})
+	if err != nil {
+		return nil, err
+	}
+	return receipts[0], nil
+}
+
+// GetTransactionByBlockNumberAndIndex returns the transaction at the given index of the block by
+// number as served by the block archiver, failing with a *TransactionNotFoundError if the block
+// has no transaction at that index. See Transaction.ToTransaction to convert it.
+func (c *Client) GetTransactionByBlockNumberAndIndex(ctx context.Context, number uint64, index uint64) (*Transaction, error) {
+	ctx, done := c.methodContext(ctx, "GetTransactionByBlockNumberAndIndex")
+	defer done()
+	ctx = withBlock(ctx, number)
+	payload := preparePayload(c.methods.GetTransactionByIndex, []interface{}{Int64ToHex(int64(number)), Int64ToHex(int64(index))})
+	body, err := c.postRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	getTransactionResp := GetTransactionResponse{}
+	err = unmarshalJSON(body, &getTransactionResp)
+	if err != nil {
+		return nil, err
+	}
+	if getTransactionResp.Error != nil {
+		return nil, getTransactionResp.Error
+	}
+	if getTransactionResp.Result == nil {
+		return nil, &TransactionNotFoundError{Number: number, Index: index}
+	}
+	return getTransactionResp.Result, nil
+}
+
 func (c *Client) GetBlockByNumber(ctx context.Context, number uint64) (*Block, error) {
-	payload := preparePayload("eth_getBlockByNumber", []interface{}{Int64ToHex(int64(number)), "true"})
+	ctx, done := c.methodContext(ctx, "GetBlockByNumber")
+	defer done()
+	ctx = withBlock(ctx, number)
+	payload := preparePayload(c.methods.GetBlockByNumber, []interface{}{Int64ToHex(int64(number)), "true"})
 	body, err := c.postRequest(ctx, payload)
 	if err != nil {
 		return nil, err
 	}
 	getBlockResp := GetBlockResponse{}
-	err = json.Unmarshal(body, &getBlockResp)
+	err = unmarshalJSON(body, &getBlockResp)
 	if err != nil {
 		return nil, err
 	}
+	if getBlockResp.Error != nil {
+		return nil, getBlockResp.Error
+	}
 	return getBlockResp.Result, nil
 }
 
+// GetBlockReceiptsByHash returns the receipts of the block by hash, nil if the block is unknown
+func (c *Client) GetBlockReceiptsByHash(ctx context.Context, hash common.Hash) ([]*Receipt, error) {
+	ctx, done := c.methodContext(ctx, "GetBlockReceiptsByHash")
+	defer done()
+	payload := preparePayload(c.methods.GetBlockReceipts, []interface{}{hash.String()})
+	body, err := c.postRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	getReceiptsResp := GetBlockReceiptsResponse{}
+	err = unmarshalJSON(body, &getReceiptsResp)
+	if err != nil {
+		return nil, err
+	}
+	if getReceiptsResp.Error != nil {
+		return nil, getReceiptsResp.Error
+	}
+	return getReceiptsResp.Result, nil
+}
+
+// GetLogs returns the logs matching the filter, in the order of the chain
+func (c *Client) GetLogs(ctx context.Context, filter LogFilter) ([]types.Log, error) {
+	ctx, done := c.methodContext(ctx, "GetLogs")
+	defer done()
+	if filter.FromBlock > filter.ToBlock {
+		return nil, fmt.Errorf("invalid block range [%d, %d]", filter.FromBlock, filter.ToBlock)
+	}
+	payload := preparePayload(c.methods.GetLogs, []interface{}{filter.toArg()})
+	body, err := c.postRequest(withBlock(ctx, filter.FromBlock), payload)
+	if err != nil {
+		return nil, err
+	}
+	getLogsResp := GetLogsResponse{}
+	err = unmarshalJSON(body, &getLogsResp)
+	if err != nil {
+		return nil, err
+	}
+	if getLogsResp.Error != nil {
+		return nil, getLogsResp.Error
+	}
+	logs := make([]types.Log, 0, len(getLogsResp.Result))
+	for _, l := range getLogsResp.Result {
+		entry, err := convertLog(l)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, entry)
+	}
+	return logs, nil
+}
+
+// GetBlocksByNumbers returns the blocks by number with their transactions, sent as batches of
+// JSON-RPC requests correlated with their responses by id. The blocks are in the order of the
+// numbers, the unknown blocks are nil. An error returned for any block fails the whole call.
+func (c *Client) GetBlocksByNumbers(ctx context.Context, numbers []uint64) ([]*Block, error) {
+	ctx, done := c.methodContext(ctx, "GetBlocksByNumbers")
+	defer done()
+	return c.getBlocksByNumbers(ctx, numbers)
+}
+
+// GetBlocksByRange returns the blocks in [from, to] with their transactions in ascending order,
+// fetched in JSON-RPC batches. It fails if the range spans more than the WithMaxBlockRange limit
+// or if the archiver misses a block of the range.
+func (c *Client) GetBlocksByRange(ctx context.Context, from, to uint64) ([]*Block, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid block range [%d, %d]", from, to)
+	}
+	if span := to - from; span >= c.maxBlockRange {
+		return nil, fmt.Errorf("block range [%d, %d] exceeds the limit of %d blocks", from, to, c.maxBlockRange)
+	}
+	ctx, done := c.methodContext(ctx, "GetBlocksByRange")
+	defer done()
+	numbers := make([]uint64, 0, to-from+1)
+	for number := from; number <= to; number++ {
+		numbers = append(numbers, number)
+	}
+	blocks, err := c.getBlocksByNumbers(ctx, numbers)
+	if err != nil {
+		return nil, err
+	}
+	for i, block := range blocks {
+		if block == nil {
+			return nil, fmt.Errorf("%w: number %d", ErrBlockNotFound, numbers[i])
+		}
+	}
+	return blocks, nil
+}
+
+// getBlocksByNumbers fetches the blocks by number in batches of blockBatchSize, see
+// GetBlocksByNumbers
+func (c *Client) getBlocksByNumbers(ctx context.Context, numbers []uint64) ([]*Block, error) {
+	blocks := make([]*Block, len(numbers))
+	for start := 0; start < len(numbers); start += blockBatchSize {
+		end := start + blockBatchSize
+		if end > len(numbers) {
+			end = len(numbers)
+		}
+		payloads := make([]map[string]interface{}, 0, end-start)
+		for i, number := range numbers[start:end] {
+			payloads = append(payloads, preparePayloadWithID(i+1, c.methods.GetBlockByNumber, []interface{}{Int64ToHex(int64(number)), "true"}))
+		}
+		body, err := c.postRequest(withBlock(ctx, numbers[start]), payloads)
+		if err != nil {
+			return nil, err
+		}
+		var responses []GetBlockResponse
+		if err := unmarshalJSON(body, &responses); err != nil {
+			return nil, err
+		}
+		batch := blocks[start:end]
+		seen := make([]bool, len(batch))
+		for _, resp := range responses {
+			index := resp.ID - 1
+			if index < 0 || index >= int64(len(batch)) {
+				return nil, fmt.Errorf("unexpected response id %d in batch of %d blocks", resp.ID, len(batch))
+			}
+			if resp.Error != nil {
+				return nil, fmt.Errorf("block %d: %w", numbers[start+int(index)], resp.Error)
+			}
+			if seen[index] {
+				return nil, fmt.Errorf("duplicate response id %d in batch of %d blocks", resp.ID, len(batch))
+			}
+			seen[index] = true
+			batch[index] = resp.Result
+		}
+		for i, block := range batch {
+			if block == nil {
+				continue
+			}
+			if number, err := HexToUint64(block.Number); err != nil || number != numbers[start+i] {
+				return nil, fmt.Errorf("got block %s for block %d", block.Number, numbers[start+i])
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// GetBlockReceipts returns the receipts of the block by number, nil if the block is unknown
+func (c *Client) GetBlockReceipts(ctx context.Context, number uint64) ([]*Receipt, error) {
+	ctx, done := c.methodContext(ctx, "GetBlockReceipts")
+	defer done()
+	ctx = withBlock(ctx, number)
+	payload := preparePayload(c.methods.GetBlockReceipts, []interface{}{Int64ToHex(int64(number))})
+	body, err := c.postRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	getReceiptsResp := GetBlockReceiptsResponse{}
+	err = unmarshalJSON(body, &getReceiptsResp)
+	if err != nil {
+		return nil, err
+	}
+	if getReceiptsResp.Error != nil {
+		return nil, getReceiptsResp.Error
+	}
+	return getReceiptsResp.Result, nil
+}
+
+// GetBlockHeaderByHash returns the block by hash without transaction details, the transactions
+// of the returned block are left empty
+func (c *Client) GetBlockHeaderByHash(ctx context.Context, hash common.Hash) (*Block, error) {
+	ctx, done := c.methodContext(ctx, "GetBlockHeaderByHash")
+	defer done()
+	payload := preparePayload(c.methods.GetBlockByHash, []interface{}{hash.String(), "false"})
+	return c.getBlockHeaderResponse(ctx, payload)
+}
+
+// GetBlockHeaderByNumber returns the block by number without transaction details, the
+// transactions of the returned block are left empty
+func (c *Client) GetBlockHeaderByNumber(ctx context.Context, number uint64) (*Block, error) {
+	ctx, done := c.methodContext(ctx, "GetBlockHeaderByNumber")
+	defer done()
+	return c.getBlockHeader(withBlock(ctx, number), Int64ToHex(int64(number)))
+}
+
+// getBlockHeader returns the block by number or tag without transaction details
+func (c *Client) getBlockHeader(ctx context.Context, numberOrTag string) (*Block, error) {
+	payload := preparePayload(c.methods.GetBlockByNumber, []interface{}{numberOrTag, "false"})
+	return c.getBlockHeaderResponse(ctx, payload)
+}
+
+// getBlockHeaderResponse sends a block request without transaction details and decodes its result
+func (c *Client) getBlockHeaderResponse(ctx context.Context, payload interface{}) (*Block, error) {
+	body, err := c.postRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	getBlockResp := GetBlockWithTxHashesResponse{}
+	err = unmarshalJSON(body, &getBlockResp)
+	if err != nil {
+		return nil, err
+	}
+	if getBlockResp.Error != nil {
+		return nil, getBlockResp.Error
+	}
+	if getBlockResp.Result == nil {
+		return nil, nil
+	}
+	return &getBlockResp.Result.Block, nil
+}
+
 func (c *Client) GetLatestBlock(ctx context.Context) (*Block, error) {
-	payload := preparePayload("eth_getBlockByNumber", []interface{}{"latest", "true"})
+	ctx, done := c.methodContext(ctx, "GetLatestBlock")
+	defer done()
+	return c.getBlockByTag(ctx, BlockTagLatest)
+}
+
+// GetBlockByTag returns the block named by the tag with its transactions, nil if the archiver
+// doesn't know it, e.g. the pending block. Unknown tags are rejected without a request.
+func (c *Client) GetBlockByTag(ctx context.Context, tag BlockTag) (*Block, error) {
+	if _, err := ParseBlockTag(string(tag)); err != nil {
+		return nil, err
+	}
+	ctx, done := c.methodContext(ctx, "GetBlockByTag")
+	defer done()
+	return c.getBlockByTag(ctx, tag)
+}
+
+// getBlockByTag returns the block by tag with its transactions
+func (c *Client) getBlockByTag(ctx context.Context, tag BlockTag) (*Block, error) {
+	payload := preparePayload(c.methods.GetBlockByNumber, []interface{}{string(tag), "true"})
 	body, err := c.postRequest(ctx, payload)
 	if err != nil {
 		return nil, err
 	}
 	getBlockResp := GetBlockResponse{}
-	err = json.Unmarshal(body, &getBlockResp)
+	err = unmarshalJSON(body, &getBlockResp)
 	if err != nil {
 		return nil, err
 	}
+	if getBlockResp.Error != nil {
+		return nil, getBlockResp.Error
+	}
 	return getBlockResp.Result, nil
 }
 
 // GetBundleName returns the bundle name by a specific block number
-func (c *Client) GetBundleName(ctx context.Context, blockNum uint64) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", c.blockArchiverHost+fmt.Sprintf("/bsc/v1/blocks/%d/bundle/name", blockNum), nil)
+func (c *Client) GetBundleName(ctx context.Context, blockNum uint64) (_ string, err error) {
+	ctx, done := c.methodContext(ctx, "GetBundleName")
+	defer done()
+	defer func(start time.Time) { c.rest.record(start, err) }(time.Now())
+	ctx, cancel := c.rest.context(withBlock(ctx, blockNum))
+	defer cancel()
+
+	var body []byte
+	err = c.tryHosts(ctx, func(host string) (err error) {
+		body, err = c.get(ctx, "bundle_name", host+fmt.Sprintf("/bsc/v1/blocks/%d/bundle/name", blockNum))
+		return err
+	})
 	if err != nil {
-		return "", err
+		return "", unavailable(err)
 	}
-	resp, err := c.hc.Do(req)
+	getBundleNameResp := GetBundleNameResponse{}
+	err = unmarshalJSON(body, &getBundleNameResp)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.New("failed to get bundle name")
+	return getBundleNameResp.Data, nil
+}
+
+// GetBundleMetadata returns the metadata of the bundle holding the given block number without
+// fetching its blocks. Archivers without the metadata endpoint only give the bundle name, the
+// metadata is then derived from it and the size left unknown.
+func (c *Client) GetBundleMetadata(ctx context.Context, blockNum uint64) (_ *BundleMetadata, err error) {
+	ctx, done := c.methodContext(ctx, "GetBundleMetadata")
+	defer done()
+	defer func(start time.Time) { c.rest.record(start, err) }(time.Now())
+	ctx, cancel := c.rest.context(withBlock(ctx, blockNum))
+	defer cancel()
+
+	var body []byte
+	err = c.tryHosts(ctx, func(host string) (err error) {
+		body, err = c.get(ctx, "bundle_metadata", host+fmt.Sprintf("/bsc/v1/blocks/%d/bundle/metadata", blockNum))
+		return err
+	})
+	if errors.Is(err, errNotFound) {
+		info, err := c.getBundleInfo(ctx, blockNum)
+		if err != nil {
+			return nil, err
+		}
+		return &BundleMetadata{BundleInfo: *info, BlockCount: info.To - info.From + 1}, nil
 	}
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, unavailable(err)
 	}
-	getBundleNameResp := GetBundleNameResponse{}
-	err = json.Unmarshal(body, &getBundleNameResp)
+	resp := GetBundleMetadataResponse{}
+	if err := unmarshalJSON(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data == nil {
+		return nil, errors.New("empty bundle metadata")
+	}
+	info, err := NewBundleInfo(resp.Data.Name)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return getBundleNameResp.Data, nil
+	return &BundleMetadata{BundleInfo: *info, BlockCount: resp.Data.BlockCount, Size: resp.Data.Size}, nil
 }
 
-// GetBundleBlocksByBlockNum returns the bundle blocks by block number that within the range
-func (c *Client) GetBundleBlocksByBlockNum(ctx context.Context, blockNum uint64) ([]*Block, error) {
-	payload := preparePayload("eth_getBundledBlockByNumber", []interface{}{Int64ToHex(int64(blockNum))})
-	body, err := c.postRequest(ctx, payload)
+// GetHeadersByRange returns the blocks in [from, to] without transaction details, the range is
+// split in batches of JSON-RPC requests which are correlated with their responses by id
+func (c *Client) GetHeadersByRange(ctx context.Context, from, to uint64) ([]*Block, error) {
+	ctx, done := c.methodContext(ctx, "GetHeadersByRange")
+	defer done()
+	if from > to {
+		return nil, fmt.Errorf("invalid block range [%d, %d]", from, to)
+	}
+	blocks := make([]*Block, 0, to-from+1)
+	for start := from; start <= to; start += headerBatchSize {
+		end := start + headerBatchSize - 1
+		if end > to || end < start {
+			end = to
+		}
+		payloads := make([]map[string]interface{}, 0, end-start+1)
+		for number := start; number <= end; number++ {
+			payloads = append(payloads, preparePayloadWithID(int(number-start)+1, c.methods.GetBlockByNumber, []interface{}{Int64ToHex(int64(number)), "false"}))
+		}
+		body, err := c.postRequest(withBlock(ctx, start), payloads)
+		if err != nil {
+			return nil, err
+		}
+		var responses []GetBlockWithTxHashesResponse
+		if err := unmarshalJSON(body, &responses); err != nil {
+			return nil, err
+		}
+		batch := make([]*Block, len(payloads))
+		for _, resp := range responses {
+			index := resp.ID - 1
+			if index < 0 || index >= int64(len(batch)) {
+				return nil, fmt.Errorf("unexpected response id %d in batch [%d, %d]", resp.ID, start, end)
+			}
+			if resp.Error != nil {
+				return nil, fmt.Errorf("block %d: %w", start+uint64(index), resp.Error)
+			}
+			if batch[index] != nil {
+				return nil, fmt.Errorf("duplicate response id %d in batch [%d, %d]", resp.ID, start, end)
+			}
+			if resp.Result != nil {
+				batch[index] = &resp.Result.Block
+			}
+		}
+		for i, block := range batch {
+			if block == nil {
+				return nil, fmt.Errorf("%w: number %d", ErrBlockNotFound, start+uint64(i))
+			}
+		}
+		blocks = append(blocks, batch...)
+		if end == to {
+			break
+		}
+	}
+	if err := checkContiguous(blocks, from, to); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// checkContiguous checks that the blocks are exactly the blocks in [from, to] in ascending
+// order, the error points at the first gap, duplicate or misplaced block
+func checkContiguous(blocks []*Block, from, to uint64) error {
+	if want := to - from + 1; uint64(len(blocks)) != want {
+		return fmt.Errorf("got %d blocks for range [%d, %d] of %d blocks", len(blocks), from, to, want)
+	}
+	for i, block := range blocks {
+		want := from + uint64(i)
+		number, err := HexToUint64(block.Number)
+		if err != nil {
+			return fmt.Errorf("block at position %d of range [%d, %d]: invalid number %q", i, from, to, block.Number)
+		}
+		switch {
+		case number == want:
+		case i > 0 && number == want-1:
+			return fmt.Errorf("duplicate block %d in range [%d, %d]", number, from, to)
+		case number > want:
+			return fmt.Errorf("gap in range [%d, %d]: block %d missing, got block %d", from, to, want, number)
+		default:
+			return fmt.Errorf("out of order block in range [%d, %d]: want block %d, got block %d", from, to, want, number)
+		}
+	}
+	return nil
+}
+
+// GetLatestFinalizedBundle returns the last bundle which is fully archived and only contains
+// finalized blocks. When the finalized block falls in the middle of a bundle, the bundle right
+// below it is returned, since the one holding the finalized block may still be partially written.
+func (c *Client) GetLatestFinalizedBundle(ctx context.Context) (*BundleInfo, error) {
+	ctx, done := c.methodContext(ctx, "GetLatestFinalizedBundle")
+	defer done()
+	finalized, err := c.getBlockHeader(ctx, string(BlockTagFinalized))
+	if err != nil {
+		return nil, err
+	}
+	if finalized == nil {
+		return nil, fmt.Errorf("finalized %w", ErrBlockNotFound)
+	}
+	number, err := HexToUint64(finalized.Number)
 	if err != nil {
 		return nil, err
 	}
-	getBlocksResp := GetBlocksResponse{}
-	err = json.Unmarshal(body, &getBlocksResp)
+	bundle, err := c.getBundleInfo(ctx, number)
 	if err != nil {
 		return nil, err
 	}
-	return getBlocksResp.Result, nil
+	if bundle.To <= number {
+		return bundle, nil
+	}
+	if bundle.From == 0 {
+		return nil, fmt.Errorf("no complete bundle below finalized block %d", number)
+	}
+	return c.getBundleInfo(ctx, bundle.From-1)
+}
+
+// getBundleInfo returns the bundle holding the given block number
+func (c *Client) getBundleInfo(ctx context.Context, number uint64) (*BundleInfo, error) {
+	name, err := c.GetBundleName(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return NewBundleInfo(name)
+}
+
+// GetBundleBlocksByBlockNum returns the bundle blocks by block number that within the range.
+// Archivers paginating large bundles return a continuation token with every page but the last
+// one, the pages are followed until the token is exhausted and accumulated into the full bundle.
+func (c *Client) GetBundleBlocksByBlockNum(ctx context.Context, blockNum uint64) ([]*Block, error) {
+	ctx, done := c.methodContext(ctx, "GetBundleBlocksByBlockNum")
+	defer done()
+	var (
+		blocks []*Block
+		token  string
+	)
+	for page := 0; page < maxBundlePages; page++ {
+		params := []interface{}{Int64ToHex(int64(blockNum))}
+		if token != "" {
+			params = append(params, token)
+		}
+		payload := preparePayload(c.methods.GetBundledBlockByNumber, params)
+		body, err := c.postRequest(withBlock(ctx, blockNum), payload)
+		if err != nil {
+			return nil, err
+		}
+		getBlocksResp := GetBlocksResponse{}
+		err = unmarshalJSON(body, &getBlocksResp)
+		if err != nil {
+			return nil, err
+		}
+		if getBlocksResp.Error != nil {
+			return nil, getBlocksResp.Error
+		}
+		blocks = append(blocks, getBlocksResp.Result...)
+		if getBlocksResp.NextPageToken == "" {
+			return blocks, nil
+		}
+		token = getBlocksResp.NextPageToken
+	}
+	return nil, fmt.Errorf("bundle of block %d exceeds %d pages", blockNum, maxBundlePages)
 }
 
 // GetBundleBlocks returns the bundle blocks by object name
 func (c *Client) GetBundleBlocks(ctx context.Context, objectName string) ([]*Block, error) {
-	var urlStr string
-	parts := strings.Split(c.spHost, "//")
-	urlStr = parts[0] + "//" + c.bucketName + "." + parts[1] + "/" + objectName
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	ctx, done := c.methodContext(ctx, "GetBundleBlocks")
+	defer done()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.bundleURL(objectName), nil)
 	if err != nil {
 		return nil, err
 	}
+	start := time.Now()
 	resp, err := c.hc.Do(req)
 	if err != nil {
-		return nil, err
+		c.observe("bundle", start, 0, 0, err)
+		return nil, unavailable(err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.readBody(resp)
+	c.observe("bundle", start, resp.StatusCode, len(body), err)
 	if err != nil {
 		return nil, err
 	}
 
+	// an empty object is a bundle without blocks
+	if len(body) == 0 {
+		return nil, nil
+	}
+
 	tempFile, err := os.CreateTemp("", "bundle")
 	if err != nil {
 		fmt.Printf("Failed to create temporary file: %v\n", err)
@@ -157,20 +964,48 @@ func (c *Client) GetBundleBlocks(ctx context.Context, objectName string) ([]*Blo
 	}
 	defer tempFile.Close()
 
-	bundleObjects, err := bundlesdk.NewBundleFromFile(tempFile.Name())
+	return c.decodeBundle(ctx, tempFile.Name())
+}
+
+// decodeBundle decodes the blocks of the bundle file, checking the decode deadline between
+// the objects and while reading them
+func (c *Client) decodeBundle(ctx context.Context, path string) (_ []*Block, err error) {
+	defer func(start time.Time) {
+		if err == nil {
+			bundleDecodeTimer.UpdateSince(start)
+		}
+	}(time.Now())
+	parent := ctx
+	if c.decodeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.decodeTimeout)
+		defer cancel()
+	}
+	// tell the decode deadline apart from the deadline of the request
+	defer func() {
+		if err != nil && ctx.Err() != nil && parent.Err() == nil {
+			bundleDecodeTimeoutMeter.Mark(1)
+			err = fmt.Errorf("%w after %v", ErrDecodeTimeout, c.decodeTimeout)
+		}
+	}()
+
+	bundleObjects, err := bundlesdk.NewBundleFromFile(path)
 	if err != nil {
 		fmt.Printf("Failed to create bundle from file: %v\n", err)
 		return nil, err
 	}
 	var blocksInfo []*Block
 	for _, objMeta := range bundleObjects.GetBundleObjectsMeta() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		objFile, _, err := bundleObjects.GetObject(objMeta.Name)
 		if err != nil {
 			return nil, err
 		}
 
 		var objectInfo []byte
-		objectInfo, err = io.ReadAll(objFile)
+		objectInfo, err = io.ReadAll(&contextReader{ctx: ctx, r: objFile})
 		if err != nil {
 			objFile.Close()
 			return nil, err
@@ -178,7 +1013,7 @@ func (c *Client) GetBundleBlocks(ctx context.Context, objectName string) ([]*Blo
 		objFile.Close()
 
 		var blockInfo *Block
-		err = json.Unmarshal(objectInfo, &blockInfo)
+		err = unmarshalJSON(objectInfo, &blockInfo)
 		if err != nil {
 			return nil, err
 		}
@@ -188,42 +1023,186 @@ func (c *Client) GetBundleBlocks(ctx context.Context, objectName string) ([]*Blo
 	return blocksInfo, nil
 }
 
+// contextReader fails the reads once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// hostKey is the context key of the per-call block archiver host override
+type hostKey struct{}
+
+// WithHost returns a context which makes the client send the JSON-RPC requests made with it to
+// the given block archiver host instead of the configured one
+func WithHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, hostKey{}, host)
+}
+
+// bundleURL returns the url of a bundle object in the bucket of the storage provider
+func (c *Client) bundleURL(objectName string) string {
+	parts := strings.Split(c.spHost, "//")
+	return parts[0] + "//" + c.bucketName + "." + parts[1] + "/" + objectName
+}
+
 // postRequest sends a POST request to the block archiver service
-func (c *Client) postRequest(ctx context.Context, payload map[string]interface{}) ([]byte, error) {
+func (c *Client) postRequest(ctx context.Context, payload interface{}) (_ []byte, err error) {
+	method := payloadMethod(payload)
+	if tenant := c.tenantOf(ctx); tenant != "" {
+		start := time.Now()
+		defer func() { recordTenantRequest(tenant, method, start, err) }()
+	}
+	defer func(start time.Time) { c.rpc.record(start, err) }(time.Now())
+	ctx, cancel := c.rpc.context(ctx)
+	defer cancel()
+
 	// Encode payload to JSON
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
 
-	// post call to block archiver
-	req, err := http.NewRequestWithContext(ctx, "POST", c.blockArchiverHost, bytes.NewBuffer(payloadBytes))
+	// post call to block archiver, failing over to the next hosts of the ring if any and retrying
+	// once every host failed
+	var body []byte
+	err = c.retry(ctx, c.rpc, func() error {
+		return c.tryHosts(ctx, func(host string) (err error) {
+			body, err = c.post(ctx, host, method, payloadBytes)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	return body, nil
+}
+
+// post sends a JSON-RPC request to a single block archiver host, the method only labels the
+// request for the observer
+func (c *Client) post(ctx context.Context, host, method string, payloadBytes []byte) (body []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", host, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	var statusCode int
+	defer func() { c.observe(method, start, statusCode, len(body), err) }()
 	// Perform the HTTP request
 	resp, err := c.hc.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	statusCode = resp.StatusCode
+	defer resp.Body.Close()
+	defer func() { err = c.diagnose(resp, err) }()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to get response")
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+	body, err = c.readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkJSONResponse(resp, body); err != nil {
+		return nil, err
 	}
+	return body, nil
+}
+
+// get sends a REST request to the given url of a block archiver host, the method only labels
+// the request for the observer
+func (c *Client) get(ctx context.Context, method, url string) (body []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var statusCode int
+	defer func() { c.observe(method, start, statusCode, len(body), err) }()
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	statusCode = resp.StatusCode
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	defer func() { err = c.diagnose(resp, err) }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+	body, err = c.readBody(resp)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkJSONResponse(resp, body); err != nil {
+		return nil, err
+	}
 	return body, nil
 }
 
+// readBody reads the body of a response, failing with ErrResponseTooLarge past maxResponseBytes
+// without reading further
+func (c *Client) readBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.maxResponseBytes {
+		return nil, fmt.Errorf("%w: over %d bytes from %s", ErrResponseTooLarge, c.maxResponseBytes, resp.Request.URL.Host)
+	}
+	return body, nil
+}
+
+// checkJSONResponse rejects responses that are obviously not JSON, e.g. the html error page of a
+// misconfigured gateway served with a 200 status, with an error quoting the start of the body
+func checkJSONResponse(resp *http.Response, body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	contentType := resp.Header.Get("Content-Type")
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && !strings.Contains(contentType, "html") {
+		return nil
+	}
+	snippet := trimmed
+	if len(snippet) > maxErrorSnippet {
+		snippet = snippet[:maxErrorSnippet]
+	}
+	return fmt.Errorf("unexpected non-JSON response from %s, content-type %q: %q", resp.Request.URL.Host, contentType, snippet)
+}
+
 // preparePayload prepares the payload for the request
 func preparePayload(method string, params []interface{}) map[string]interface{} {
+	return preparePayloadWithID(1, method, params)
+}
+
+// preparePayloadWithID prepares the payload for a request of a batch, where the id correlates
+// the request with its response
+func preparePayloadWithID(id int, method string, params []interface{}) map[string]interface{} {
 	return map[string]interface{}{
 		"jsonrpc": "2.0",
 		"method":  method,
 		"params":  params,
-		"id":      1,
+		"id":      id,
+	}
+}
+
+// payloadMethod returns the method of a request payload, or "batch" for a batch of requests
+func payloadMethod(payload interface{}) string {
+	if p, ok := payload.(map[string]interface{}); ok {
+		method, _ := p["method"].(string)
+		return method
 	}
+	return "batch"
 }
@@ -0,0 +1,1417 @@
+package blockarchiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/mclock"
+)
+
+func TestWarmUp(t *testing.T) {
+	var heads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	if _, err := New(server.URL, "", "bucket", WithWarmUp(time.Second)); err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if n := heads.Load(); n != 1 {
+		t.Errorf("want 1 warm-up request, got %d", n)
+	}
+	// an unreachable archiver must not fail the construction
+	server.Close()
+	if _, err := New(server.URL, "", "bucket", WithWarmUp(time.Second)); err != nil {
+		t.Fatalf("failed warm-up broke client creation: %v", err)
+	}
+}
+
+func TestNonJSONResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>502 Bad Gateway</h1></body></html>"))
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.GetLatestBlock(context.Background())
+	if err == nil {
+		t.Fatal("want error on html response, got nil")
+	}
+	if !strings.Contains(err.Error(), "non-JSON") || !strings.Contains(err.Error(), "502 Bad Gateway") {
+		t.Errorf("error doesn't describe the html response: %v", err)
+	}
+	_, err = client.GetBundleName(context.Background(), 100)
+	if err == nil || !strings.Contains(err.Error(), "502 Bad Gateway") {
+		t.Errorf("want html response error from bundle name lookup, got %v", err)
+	}
+}
+
+func TestGetLatestFinalizedBundle(t *testing.T) {
+	tests := []struct {
+		finalized uint64
+		from, to  uint64
+	}{
+		{finalized: 109, from: 100, to: 109},
+		{finalized: 105, from: 90, to: 99},
+		{finalized: 100, from: 90, to: 99},
+	}
+	for _, tt := range tests {
+		archiver := newTestArchiver(newTestChain(t, tt.finalized, 1)...)
+		archiver.finalized = tt.finalized
+		server := httptest.NewServer(archiver)
+
+		client, err := New(server.URL, server.URL, "bucket")
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		bundle, err := client.GetLatestFinalizedBundle(context.Background())
+		server.Close()
+		if err != nil {
+			t.Fatalf("finalized %d: failed to get bundle: %v", tt.finalized, err)
+		}
+		if bundle.From != tt.from || bundle.To != tt.to {
+			t.Errorf("finalized %d: want bundle [%d, %d], got [%d, %d]", tt.finalized, tt.from, tt.to, bundle.From, bundle.To)
+		}
+	}
+	archiver := newTestArchiver(newTestChain(t, 5, 1)...)
+	archiver.finalized = 5
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, _ := New(server.URL, server.URL, "bucket")
+	if _, err := client.GetLatestFinalizedBundle(context.Background()); err == nil {
+		t.Error("want error when the first bundle is incomplete, got nil")
+	}
+}
+
+func TestGetBundleBlocksByBlockNumPagination(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	archiver.pageSize = 6
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	blocks, err := client.GetBundleBlocksByBlockNum(context.Background(), 105)
+	if err != nil {
+		t.Fatalf("failed to get bundle blocks: %v", err)
+	}
+	if n := archiver.callCount("eth_getBundledBlockByNumber"); n != 2 {
+		t.Errorf("want 2 page requests, got %d", n)
+	}
+	if len(blocks) != 10 {
+		t.Fatalf("want 10 blocks, got %d", len(blocks))
+	}
+	for i, block := range blocks {
+		if number, _ := HexToUint64(block.Number); number != uint64(100+i) {
+			t.Errorf("block %d: want number %d, got %d", i, 100+i, number)
+		}
+	}
+}
+
+func TestTenantOf(t *testing.T) {
+	client, err := New("http://localhost", "", "bucket", WithTenants("indexer", "explorer"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	tests := []struct {
+		ctx  context.Context
+		want string
+	}{
+		{ctx: context.Background(), want: ""},
+		{ctx: WithTenant(context.Background(), "indexer"), want: "indexer"},
+		{ctx: WithTenant(context.Background(), "explorer"), want: "explorer"},
+		{ctx: WithTenant(context.Background(), "unknown"), want: otherTenant},
+	}
+	for _, tt := range tests {
+		if got := client.tenantOf(tt.ctx); got != tt.want {
+			t.Errorf("want tenant %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestEndpointPolicy(t *testing.T) {
+	blocks := newTestChain(t, 100, 1)
+	archiver := newTestArchiver(blocks...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the REST gateway is degraded while the JSON-RPC gateway is healthy
+		if r.Method == http.MethodGet {
+			time.Sleep(200 * time.Millisecond)
+		}
+		archiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket",
+		WithRESTPolicy(EndpointPolicy{Timeout: 50 * time.Millisecond}),
+		WithRPCPolicy(EndpointPolicy{Backoff: &ConstantBackoff{Delay: time.Second}}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if client.rest.timeout != 50*time.Millisecond || client.rpc.timeout != 0 {
+		t.Errorf("timeouts mismatch, rest %v, rpc %v", client.rest.timeout, client.rpc.timeout)
+	}
+	if _, ok := client.rpc.backoff.(*ConstantBackoff); !ok {
+		t.Errorf("want constant rpc backoff, got %T", client.rpc.backoff)
+	}
+	if _, ok := client.rest.backoff.(*ExponentialBackoff); !ok {
+		t.Errorf("want default rest backoff, got %T", client.rest.backoff)
+	}
+	if _, err := client.GetBundleName(context.Background(), 100); err == nil {
+		t.Error("want timeout from the degraded REST endpoint, got nil")
+	}
+	if _, err := client.GetLatestBlock(context.Background()); err != nil {
+		t.Errorf("failed to get latest block from the healthy RPC endpoint: %v", err)
+	}
+}
+
+func TestEndpointFailures(t *testing.T) {
+	e := newEndpoint("test")
+	for i := 0; i < 2; i++ {
+		e.record(time.Now(), &StatusError{StatusCode: http.StatusBadGateway})
+	}
+	if n := e.failures.Load(); n != 2 {
+		t.Errorf("want 2 consecutive failures, got %d", n)
+	}
+	// the errors a retry can't fix don't count against the endpoint
+	for _, err := range []error{&StatusError{StatusCode: http.StatusNotFound}, context.Canceled} {
+		e.record(time.Now(), err)
+		if n := e.failures.Load(); n != 0 {
+			t.Errorf("%v: want the failures reset, got %d", err, n)
+		}
+	}
+}
+
+func TestMethodTimeouts(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		archiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket",
+		WithRPCPolicy(EndpointPolicy{Timeout: 50 * time.Millisecond}),
+		WithMethodTimeouts(map[string]time.Duration{
+			"GetBundleBlocksByBlockNum": time.Second,
+			"GetLatestBlock":            20 * time.Millisecond,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	// the method timeout replaces the shorter endpoint timeout
+	if _, err := client.GetBundleBlocksByBlockNum(context.Background(), 105); err != nil {
+		t.Errorf("failed to get bundle blocks within the method timeout: %v", err)
+	}
+	// methods without a timeout fall back to the endpoint timeout
+	if _, err := client.GetBlockByNumber(context.Background(), 105); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want endpoint timeout, got %v", err)
+	}
+	if _, err := client.GetLatestBlock(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want method timeout, got %v", err)
+	}
+	// the caller's deadline still applies
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetBundleBlocksByBlockNum(ctx, 105); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want caller deadline, got %v", err)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	// a few kilobytes on the wire inflating to 8MB
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(`{"result": "`))
+		zw.Write(make([]byte, 8<<20))
+		zw.Close()
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket", WithMaxResponseBytes(1<<20), WithCompression(true))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.GetLatestBlock(context.Background()); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("want %v, got %v", ErrResponseTooLarge, err)
+	}
+	if _, err := client.GetBundleName(context.Background(), 100); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("want %v, got %v", ErrResponseTooLarge, err)
+	}
+}
+
+// endlessTransport answers every request with a 200 response streaming zeroes forever
+type endlessTransport struct{}
+
+func (endlessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(endlessReader{}),
+		Request:    req,
+	}, nil
+}
+
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestMaxResponseBytesStream(t *testing.T) {
+	client, err := New("http://archiver", "http://sp", "bucket", WithHTTPClient(&http.Client{Transport: endlessTransport{}}), WithMaxResponseBytes(1<<20))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.GetBlockByNumber(context.Background(), 100); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("want %v for the rpc response, got %v", ErrResponseTooLarge, err)
+	}
+	if _, err := client.GetBundleBlocks(context.Background(), "blocks_s100_e199"); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("want %v for the bundle, got %v", ErrResponseTooLarge, err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	chain := newTestChain(t, 100, 1)
+	archiver := newTestArchiver(chain...)
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Accept-Encoding")
+		encodings = append(encodings, encoding)
+		if encoding != "gzip" {
+			archiver.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		archiver.ServeHTTP(rec, r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(rec.Body.Bytes())
+		zw.Close()
+	}))
+	defer server.Close()
+
+	for _, enabled := range []bool{false, true} {
+		encodings = nil
+		client, err := New(server.URL, server.URL, "bucket", WithCompression(enabled))
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		block, err := client.GetBlockByNumber(context.Background(), 100)
+		if err != nil {
+			t.Fatalf("compression %v: failed to get block: %v", enabled, err)
+		}
+		if block == nil || block.Hash != chain[0].Hash {
+			t.Errorf("compression %v: block mismatch, want %s, got %v", enabled, chain[0].Hash, block)
+		}
+		if want := map[bool]string{false: "", true: "gzip"}[enabled]; len(encodings) != 1 || encodings[0] != want {
+			t.Errorf("compression %v: want Accept-Encoding %q, got %q", enabled, want, encodings)
+		}
+		client.Close()
+	}
+}
+
+func TestGetBlocksByNumbers(t *testing.T) {
+	chain := newTestChain(t, 100, blockBatchSize+5)
+	archiver := newTestArchiver(chain...)
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	// the blocks follow the order of the numbers, the unknown block is nil
+	numbers := []uint64{103, 100, 500, 101}
+	blocks, err := client.GetBlocksByNumbers(context.Background(), numbers)
+	if err != nil {
+		t.Fatalf("failed to get blocks: %v", err)
+	}
+	if len(blocks) != len(numbers) {
+		t.Fatalf("want %d blocks, got %d", len(numbers), len(blocks))
+	}
+	for i, number := range numbers {
+		if number == 500 {
+			if blocks[i] != nil {
+				t.Errorf("want a nil unknown block, got %s", blocks[i].Number)
+			}
+			continue
+		}
+		if blocks[i] == nil || blocks[i].Hash != chain[number-100].Hash {
+			t.Errorf("block %d mismatch", number)
+		}
+	}
+	if n := archiver.callCount("batch"); n != 1 {
+		t.Errorf("want a single batch, got %d", n)
+	}
+
+	// more numbers than a batch holds are split in several batches
+	numbers = numbers[:0]
+	for i := range chain {
+		numbers = append(numbers, uint64(100+i))
+	}
+	if blocks, err = client.GetBlocksByNumbers(context.Background(), numbers); err != nil {
+		t.Fatalf("failed to get blocks: %v", err)
+	}
+	for i, block := range blocks {
+		if block == nil || block.Hash != chain[i].Hash {
+			t.Errorf("block %d mismatch", numbers[i])
+		}
+	}
+	if n := archiver.callCount("batch"); n != 3 {
+		t.Errorf("want 2 more batches, got %d in total", n)
+	}
+}
+
+func TestGetBlocksByRange(t *testing.T) {
+	chain := newTestChain(t, 100, blockBatchSize+5)
+	archiver := newTestArchiver(chain...)
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket", WithMaxBlockRange(blockBatchSize+5))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	to := uint64(100 + blockBatchSize + 4)
+	blocks, err := client.GetBlocksByRange(context.Background(), 100, to)
+	if err != nil {
+		t.Fatalf("failed to get blocks: %v", err)
+	}
+	if len(blocks) != len(chain) {
+		t.Fatalf("want %d blocks, got %d", len(chain), len(blocks))
+	}
+	for i, block := range blocks {
+		if block.Hash != chain[i].Hash {
+			t.Errorf("block %d mismatch, want %s, got %s", 100+i, chain[i].Hash, block.Hash)
+		}
+	}
+	if n := archiver.callCount("batch"); n != 2 {
+		t.Errorf("want 2 batch requests, got %d", n)
+	}
+	// ranges over the limit are rejected before any request
+	if _, err := client.GetBlocksByRange(context.Background(), 100, to+1); err == nil {
+		t.Error("want range limit error, got nil")
+	}
+	if _, err := client.GetBlocksByRange(context.Background(), 101, 100); err == nil {
+		t.Error("want invalid range error, got nil")
+	}
+	if n := archiver.callCount("batch"); n != 2 {
+		t.Errorf("want no request for rejected ranges, got %d", n-2)
+	}
+	// a range with a block the archiver doesn't serve fails
+	if _, err := client.GetBlocksByRange(context.Background(), to-1, to+1); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("want %v for a missing block, got %v", ErrBlockNotFound, err)
+	}
+}
+
+// recordingObserver keeps the requests it observes
+type recordingObserver struct {
+	mu       sync.Mutex
+	requests []RequestInfo
+}
+
+func (o *recordingObserver) ObserveRequest(info RequestInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = append(o.requests, info)
+}
+
+func (o *recordingObserver) take() []RequestInfo {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	requests := o.requests
+	o.requests = nil
+	return requests
+}
+
+func TestObserver(t *testing.T) {
+	chain := newTestChain(t, 100, 10)
+	server := httptest.NewServer(newTestArchiver(chain...))
+	defer server.Close()
+	observer := new(recordingObserver)
+	client, err := New(server.URL, server.URL, "bucket", WithObserver(observer))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	sp, _ := url.Parse(server.URL)
+	client.hc.Transport = bundleRouter{host: sp.Host, next: client.hc.Transport}
+
+	ctx := context.Background()
+	if _, err := client.GetBlockByNumber(ctx, 100); err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	if _, err := client.GetHeadersByRange(ctx, 100, 101); err != nil {
+		t.Fatalf("failed to get headers: %v", err)
+	}
+	if _, err := client.GetBundleBlocksByBlockNum(ctx, 100); err != nil {
+		t.Fatalf("failed to get bundled blocks: %v", err)
+	}
+	name, err := client.GetBundleName(ctx, 100)
+	if err != nil {
+		t.Fatalf("failed to get bundle name: %v", err)
+	}
+	if _, err := client.GetBundleBlocks(ctx, name); err != nil {
+		t.Fatalf("failed to get bundle: %v", err)
+	}
+	requests := observer.take()
+	var methods []string
+	for _, info := range requests {
+		methods = append(methods, info.Method)
+		if info.StatusCode != http.StatusOK || info.Bytes == 0 || info.Err != nil {
+			t.Errorf("%s: want a successful request with a body, got status %d, %d bytes, err %v", info.Method, info.StatusCode, info.Bytes, info.Err)
+		}
+	}
+	if want := []string{"eth_getBlockByNumber", "batch", "eth_getBundledBlockByNumber", "bundle_name", "bundle"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("observed methods mismatch, want %v, got %v", want, methods)
+	}
+
+	// failed requests are observed with their status and error
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	client, err = New(down.URL, down.URL, "bucket", WithObserver(observer))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.GetLatestBlock(ctx)
+	requests = observer.take()
+	if len(requests) != 1 || requests[0].StatusCode != http.StatusBadGateway || requests[0].Err == nil || requests[0].Bytes != 0 {
+		t.Errorf("want a single failed request, got %+v", requests)
+	}
+}
+
+func TestPing(t *testing.T) {
+	archiver := newTestArchiver()
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("failed to ping archiver: %v", err)
+	}
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get chain id: %v", err)
+	}
+	if chainID.Cmp(testChainID) != 0 {
+		t.Errorf("chain id mismatch, want %v, got %v", testChainID, chainID)
+	}
+
+	// an endpoint answering with an error status fails the ping
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	client, err = New(down.URL, down.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var statusErr *StatusError
+	if err := client.Ping(context.Background()); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want status error, got %v", err)
+	}
+	// so does a response which isn't a JSON-RPC answer
+	junk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1}`))
+	}))
+	defer junk.Close()
+	client, err = New(junk.URL, junk.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("want ping error for a response without chain id, got nil")
+	}
+}
+
+func TestGetBlockByTag(t *testing.T) {
+	chain := newTestChain(t, 100, 5)
+	archiver := newTestArchiver(chain...)
+	archiver.finalized = 102
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	for tag, want := range map[string]string{"earliest": chain[0].Hash, "latest": chain[4].Hash, "finalized": chain[2].Hash} {
+		parsed, err := ParseBlockTag(tag)
+		if err != nil {
+			t.Fatalf("failed to parse tag %q: %v", tag, err)
+		}
+		block, err := client.GetBlockByTag(context.Background(), parsed)
+		if err != nil {
+			t.Fatalf("failed to get %s block: %v", tag, err)
+		}
+		if block == nil || block.Hash != want {
+			t.Errorf("%s block mismatch, want %s, got %v", tag, want, block)
+		}
+	}
+	// unknown tags are rejected before any request
+	calls := archiver.callCount("eth_getBlockByNumber")
+	if _, err := ParseBlockTag("head"); err == nil {
+		t.Error("want unknown tag error, got nil")
+	}
+	if _, err := client.GetBlockByTag(context.Background(), BlockTag("0x64")); err == nil {
+		t.Error("want unknown tag error, got nil")
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != calls {
+		t.Errorf("want no request for an unknown tag, got %d", n-calls)
+	}
+}
+
+func TestGetHeadersByRangeAnomalies(t *testing.T) {
+	blocks := newTestChain(t, 100, 4)
+	tests := []struct {
+		name    string
+		numbers []int // the block served for each id of the batch, -1 for a duplicated id
+	}{
+		{name: "gap", numbers: []int{0, 1, 3, 3}},
+		{name: "duplicate", numbers: []int{0, 1, 1, 3}},
+		{name: "out of order", numbers: []int{0, 2, 1, 3}},
+		{name: "duplicate id", numbers: []int{0, 1, -1, 3}},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resps := make([]interface{}, 0, len(tt.numbers))
+			for i, n := range tt.numbers {
+				id := i + 1
+				if n < 0 {
+					id, n = i, i-1
+				}
+				resps = append(resps, map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": withTxHashes(blocks[n])})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resps)
+		}))
+		client, err := New(server.URL, server.URL, "bucket")
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		_, err = client.GetHeadersByRange(context.Background(), 100, 103)
+		server.Close()
+		if err == nil {
+			t.Errorf("%s: want error, got nil", tt.name)
+		} else {
+			t.Logf("%s: %v", tt.name, err)
+		}
+	}
+	if err := checkContiguous(blocks, 100, 103); err != nil {
+		t.Errorf("contiguous blocks rejected: %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// a non-routable address, the connection attempt hangs until the dial timeout
+	client, err := New("http://10.255.255.1:81", "", "bucket", WithDialTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	start := time.Now()
+	if _, err := client.GetLatestBlock(context.Background()); err == nil {
+		t.Fatal("want dial error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("dial failure took %v, want it bounded by the dial timeout", elapsed)
+	}
+}
+
+func TestHTTPOptions(t *testing.T) {
+	client, err := New("http://archiver", "", "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	transport := client.hc.Transport.(*http.Transport)
+	if client.hc.Timeout != DefaultHTTPTimeout || transport.MaxConnsPerHost != DefaultMaxConnsPerHost || transport.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("default settings: timeout %v, max conns %d, idle timeout %v", client.hc.Timeout, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+	client, err = New("http://archiver", "", "bucket", WithTimeout(time.Minute), WithMaxConnsPerHost(8), WithIdleConnTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	transport = client.hc.Transport.(*http.Transport)
+	if client.hc.Timeout != time.Minute {
+		t.Errorf("timeout mismatch: have %v, want %v", client.hc.Timeout, time.Minute)
+	}
+	if transport.MaxConnsPerHost != 8 || transport.MaxIdleConnsPerHost != 8 {
+		t.Errorf("max conns mismatch: have %d/%d, want 8", transport.MaxConnsPerHost, transport.MaxIdleConnsPerHost)
+	}
+	if transport.IdleConnTimeout != time.Second {
+		t.Errorf("idle timeout mismatch: have %v, want %v", transport.IdleConnTimeout, time.Second)
+	}
+}
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	requests atomic.Int32
+	next     http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return t.next.RoundTrip(req)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	server := httptest.NewServer(newTestArchiver(newTestChain(t, 100, 1)...))
+	defer server.Close()
+	transport := &countingTransport{next: http.DefaultTransport}
+	hc := &http.Client{Transport: transport, Timeout: 42 * time.Second}
+	client, err := New(server.URL, server.URL, "bucket", WithHTTPClient(hc), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.GetBlockByNumber(context.Background(), 100); err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	if transport.requests.Load() == 0 {
+		t.Error("request not sent through the supplied transport")
+	}
+	// the supplied client is used verbatim
+	if client.hc != hc || hc.Timeout != 42*time.Second || hc.Transport != transport {
+		t.Errorf("supplied http client was modified: timeout %v", hc.Timeout)
+	}
+}
+
+func TestHostRing(t *testing.T) {
+	chain := newTestChain(t, 0, 40)
+	archivers := []*testArchiver{newTestArchiver(chain...), newTestArchiver(chain...)}
+	servers := []*httptest.Server{httptest.NewServer(archivers[0]), httptest.NewServer(archivers[1])}
+	for _, server := range servers {
+		defer server.Close()
+	}
+	client, err := New("", servers[0].URL, "bucket", WithHostRing(10, servers[0].URL, servers[1].URL))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// all the blocks of a bundle go to the same host
+	used := make(map[int]bool)
+	for bundle := uint64(0); bundle < 4; bundle++ {
+		before := []int{archivers[0].callCount("eth_getBlockByNumber"), archivers[1].callCount("eth_getBlockByNumber")}
+		for number := bundle * 10; number < bundle*10+10; number++ {
+			if _, err := client.GetBlockByNumber(context.Background(), number); err != nil {
+				t.Fatalf("failed to get block %d: %v", number, err)
+			}
+		}
+		for i, archiver := range archivers {
+			switch archiver.callCount("eth_getBlockByNumber") - before[i] {
+			case 10:
+				used[i] = true
+			case 0:
+			default:
+				t.Errorf("bundle %d split across hosts", bundle)
+			}
+		}
+	}
+	if len(used) != 2 {
+		t.Errorf("want the bundles spread over both hosts, got %v", used)
+	}
+
+	// requests fail over to the remaining host
+	servers[1].Close()
+	for number := uint64(0); number < 40; number += 10 {
+		block, err := client.GetBlockByNumber(context.Background(), number)
+		if err != nil {
+			t.Fatalf("failed to get block %d after failover: %v", number, err)
+		}
+		if block.Hash != chain[number].Hash {
+			t.Errorf("block %d hash mismatch, want %s, got %s", number, chain[number].Hash, block.Hash)
+		}
+	}
+}
+
+func TestHostsFailover(t *testing.T) {
+	chain := newTestChain(t, 100, 1)
+	archiver := newTestArchiver(chain...)
+	var status atomic.Int32 // the status answered by the primary host, zero to serve requests
+	var primaryRequests atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryRequests.Add(1)
+		if code := status.Load(); code != 0 {
+			w.WriteHeader(int(code))
+			return
+		}
+		archiver.ServeHTTP(w, r)
+	}))
+	defer primary.Close()
+	fallback := newTestArchiver(chain...)
+	secondary := httptest.NewServer(fallback)
+	defer secondary.Close()
+	client, err := New("", primary.URL, "bucket", WithHosts(HostPrimary, primary.URL, secondary.URL))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	clock := new(mclock.Simulated)
+	client.clock = clock
+
+	// a healthy primary serves every request
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetBlockByNumber(context.Background(), 100); err != nil {
+			t.Fatalf("failed to get block: %v", err)
+		}
+	}
+	if n := fallback.callCount("eth_getBlockByNumber"); n != 0 {
+		t.Errorf("want no request on the fallback host, got %d", n)
+	}
+	// a client error isn't failed over
+	status.Store(http.StatusBadRequest)
+	if _, err := client.GetBlockByNumber(context.Background(), 100); err == nil {
+		t.Fatal("want the client error of the primary host")
+	}
+	if n := fallback.callCount("eth_getBlockByNumber"); n != 0 {
+		t.Errorf("want no failover on a client error, got %d fallback requests", n)
+	}
+	// server errors are, until the primary is skipped
+	status.Store(http.StatusServiceUnavailable)
+	primaryRequests.Store(0)
+	for i := 0; i < hostFailureThreshold+2; i++ {
+		if _, err := client.GetBlockByNumber(context.Background(), 100); err != nil {
+			t.Fatalf("failed to get block after failover: %v", err)
+		}
+	}
+	if n := primaryRequests.Load(); n != hostFailureThreshold {
+		t.Errorf("want the failing primary tried %d times, got %d", hostFailureThreshold, n)
+	}
+	// and tried first again after the cooldown
+	status.Store(0)
+	clock.Run(hostCooldown)
+	if _, err := client.GetBlockByNumber(context.Background(), 100); err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	if n := primaryRequests.Load(); n != hostFailureThreshold+1 {
+		t.Errorf("want the primary tried again after the cooldown, got %d requests", n)
+	}
+}
+
+func TestHostsRoundRobin(t *testing.T) {
+	chain := newTestChain(t, 100, 1)
+	archivers := []*testArchiver{newTestArchiver(chain...), newTestArchiver(chain...)}
+	servers := []*httptest.Server{httptest.NewServer(archivers[0]), httptest.NewServer(archivers[1])}
+	for _, server := range servers {
+		defer server.Close()
+	}
+	client, err := New("", servers[0].URL, "bucket", WithHosts(HostRoundRobin, servers[0].URL, servers[1].URL))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := client.GetBlockByNumber(context.Background(), 100); err != nil {
+			t.Fatalf("failed to get block: %v", err)
+		}
+	}
+	for i, archiver := range archivers {
+		if n := archiver.callCount("eth_getBlockByNumber"); n != 2 {
+			t.Errorf("host %d: want 2 requests, got %d", i, n)
+		}
+	}
+}
+
+func TestGetBundleMetadata(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// without the metadata endpoint, the metadata is derived from the bundle name
+	metadata, err := client.GetBundleMetadata(context.Background(), 105)
+	if err != nil {
+		t.Fatalf("failed to get bundle metadata: %v", err)
+	}
+	if metadata.From != 100 || metadata.To != 109 || metadata.BlockCount != 10 || metadata.Size != 0 {
+		t.Errorf("unexpected name-only metadata %+v", metadata)
+	}
+	if n := archiver.callCount("bundle/name"); n != 1 {
+		t.Errorf("want 1 bundle name request, got %d", n)
+	}
+
+	archiver.bundleBytes = 4096
+	metadata, err = client.GetBundleMetadata(context.Background(), 105)
+	if err != nil {
+		t.Fatalf("failed to get bundle metadata: %v", err)
+	}
+	if metadata.Name != "blocks_s100_e109" || metadata.BlockCount != 10 || metadata.Size != 4096 {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if n := archiver.callCount("bundle/name"); n != 1 {
+		t.Errorf("want no more bundle name requests, got %d", n)
+	}
+}
+
+func TestRPCMethods(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	archiver.aliases = map[string]string{
+		"archive_getBlockByNumber": "eth_getBlockByNumber",
+		"bsc_getBundleV2":          "eth_getBundledBlockByNumber",
+	}
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket", WithRPCMethods(RPCMethods{
+		GetBlockByNumber:        "archive_getBlockByNumber",
+		GetBundledBlockByNumber: "bsc_getBundleV2",
+	}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if client.methods.GetBlockByHash != DefaultRPCMethods.GetBlockByHash {
+		t.Errorf("want default name for the unset methods, got %q", client.methods.GetBlockByHash)
+	}
+	if _, err := client.GetBlockByNumber(context.Background(), 105); err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	if _, err := client.GetHeadersByRange(context.Background(), 100, 109); err != nil {
+		t.Fatalf("failed to get headers: %v", err)
+	}
+	if blocks, err := client.GetBundleBlocksByBlockNum(context.Background(), 105); err != nil || len(blocks) != 10 {
+		t.Fatalf("failed to get bundle blocks: %d blocks, %v", len(blocks), err)
+	}
+	if n := archiver.callCount("archive_getBlockByNumber"); n != 11 {
+		t.Errorf("want 11 requests of the renamed block method, got %d", n)
+	}
+	if n := archiver.callCount("bsc_getBundleV2"); n != 1 {
+		t.Errorf("want 1 request of the renamed bundle method, got %d", n)
+	}
+	if n := archiver.callCount("eth_getBlockByNumber") + archiver.callCount("eth_getBundledBlockByNumber"); n != 0 {
+		t.Errorf("want no request of the default names, got %d", n)
+	}
+
+	for _, name := range []string{"getBlock", "eth getBlock", "eth_", "_getBlock"} {
+		if _, err := New(server.URL, server.URL, "bucket", WithRPCMethods(RPCMethods{GetBlockByNumber: name})); err == nil {
+			t.Errorf("method %q: want error, got nil", name)
+		}
+	}
+}
+
+func TestDecodeTimeout(t *testing.T) {
+	const count = 2000
+	archiver := newTestArchiver(newTestChain(t, 0, count)...)
+	archiver.bundleSize = count
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	sp, _ := url.Parse(server.URL)
+
+	for _, test := range []struct {
+		timeout time.Duration
+		err     error
+	}{
+		{timeout: 0},
+		{timeout: time.Millisecond, err: ErrDecodeTimeout},
+	} {
+		client, err := New(server.URL, server.URL, "bucket", WithDecodeTimeout(test.timeout))
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		client.hc.Transport = bundleRouter{host: sp.Host, next: client.hc.Transport}
+
+		blocks, err := client.GetBundleBlocks(context.Background(), fmt.Sprintf("blocks_s0_e%d", count-1))
+		if !errors.Is(err, test.err) {
+			t.Fatalf("timeout %v: want error %v, got %v", test.timeout, test.err, err)
+		}
+		if test.err == nil && len(blocks) != count {
+			t.Errorf("want %d blocks, got %d", count, len(blocks))
+		}
+	}
+}
+
+func TestGetRawTransactionByHash(t *testing.T) {
+	chain := newTestChain(t, 100, 1)
+	server := httptest.NewServer(newTestArchiver(chain...))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	for i, want := range chain[0].Transactions {
+		tx, err := client.GetRawTransactionByHash(context.Background(), common.HexToHash(want.Hash))
+		if err != nil {
+			t.Fatalf("transaction %d: failed to get raw transaction: %v", i, err)
+		}
+		if tx == nil || !reflect.DeepEqual(*tx, want) {
+			t.Errorf("transaction %d mismatch, want %+v, got %+v", i, want, tx)
+		}
+	}
+	tx, err := client.GetRawTransactionByHash(context.Background(), common.Hash{1})
+	if err != nil || tx != nil {
+		t.Errorf("want no transaction for an unknown hash, got %v (%v)", tx, err)
+	}
+}
+
+func TestGetTransactionReceipt(t *testing.T) {
+	txs := newTestTransactions(t)
+	receipts := newTestReceipts(txs)
+	header := newTestHeader(100, txs)
+	archiver := newTestArchiver(toWireBlock(header, txs))
+	archiver.receipts[100] = toWireReceipts(header, receipts)
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	for i, tx := range txs {
+		receipt, err := client.GetTransactionReceipt(context.Background(), tx.Hash())
+		if err != nil {
+			t.Fatalf("transaction %d: failed to get receipt: %v", i, err)
+		}
+		want := receipts[i]
+		if receipt == nil || receipt.TxHash != tx.Hash() || receipt.Status != want.Status || receipt.CumulativeGasUsed != want.CumulativeGasUsed || receipt.Bloom != want.Bloom || len(receipt.Logs) != len(want.Logs) {
+			t.Errorf("transaction %d: receipt mismatch, want %+v, got %+v", i, want, receipt)
+		}
+	}
+	receipt, err := client.GetTransactionReceipt(context.Background(), common.Hash{1})
+	if err != nil || receipt != nil {
+		t.Errorf("want no receipt for an unknown hash, got %v (%v)", receipt, err)
+	}
+}
+
+func TestGetLogs(t *testing.T) {
+	txs := newTestTransactions(t)
+	receipts := newTestReceipts(txs)
+	header := newTestHeader(100, txs)
+	archiver := newTestArchiver(toWireBlock(header, txs))
+	archiver.receipts[100] = toWireReceipts(header, receipts)
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	// every log of the range
+	logs, err := client.GetLogs(context.Background(), LogFilter{FromBlock: 100, ToBlock: 100})
+	if err != nil {
+		t.Fatalf("failed to get logs: %v", err)
+	}
+	if len(logs) != len(receipts) {
+		t.Fatalf("want %d logs, got %d", len(receipts), len(logs))
+	}
+	for i, l := range logs {
+		want := receipts[i].Logs[0]
+		if l.Address != want.Address || l.TxHash != txs[i].Hash() || l.BlockNumber != 100 || l.BlockHash != header.Hash() || l.Index != want.Index || !bytes.Equal(l.Data, want.Data) {
+			t.Errorf("log %d mismatch: %+v", i, l)
+		}
+	}
+	// the logs matching a topic in second position only
+	second := receipts[len(receipts)-1].Logs[0].Topics[1]
+	logs, err = client.GetLogs(context.Background(), LogFilter{FromBlock: 100, ToBlock: 100, Topics: [][]common.Hash{nil, {second}}})
+	if err != nil {
+		t.Fatalf("failed to get logs by topic: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Topics[1] != second {
+		t.Errorf("want the single log with topic %s, got %+v", second, logs)
+	}
+	// an inverted range fails before any request
+	if _, err := client.GetLogs(context.Background(), LogFilter{FromBlock: 101, ToBlock: 100}); err == nil {
+		t.Error("want an error for an inverted range")
+	}
+	if n := archiver.callCount("eth_getLogs"); n != 2 {
+		t.Errorf("want 2 requests, got %d", n)
+	}
+}
+
+func TestDiagnosticHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RequestIDHeader, "req-42")
+		w.Header().Set("X-Cache", "MISS")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		opts []Option
+		want bool
+	}{
+		{want: false},
+		{opts: []Option{WithDiagnosticHeaders("x-cache")}, want: true},
+	} {
+		client, err := New(server.URL, server.URL, "bucket", test.opts...)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		_, err = client.GetBlockByNumber(context.Background(), 1)
+		if err == nil {
+			t.Fatal("want an error for a bad gateway")
+		}
+		if got := strings.Contains(err.Error(), "req-42"); got != test.want {
+			t.Errorf("request id in error %q: want %v, got %v", err, test.want, got)
+		}
+		_, err = client.GetBundleName(context.Background(), 1)
+		if got := err != nil && strings.Contains(err.Error(), "req-42"); got != test.want {
+			t.Errorf("request id in rest error %v: want %v, got %v", err, test.want, got)
+		}
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	chain := newTestChain(t, 100, 1)
+	archiver := newTestArchiver(chain...)
+	var requests, failures atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		archiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket", WithRetries(2), WithBackoff(&ConstantBackoff{Delay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// the default retries get past two failures
+	failures.Store(2)
+	if _, err := client.GetBlockByNumber(context.Background(), 100); err != nil {
+		t.Fatalf("failed to get block with retries: %v", err)
+	}
+	if n := requests.Swap(0); n != 3 {
+		t.Errorf("want 3 attempts, got %d", n)
+	}
+
+	// a zero retry override fails on the first failure
+	failures.Store(2)
+	if _, err := client.GetBlockByNumber(WithRetryCount(context.Background(), 0), 100); err == nil {
+		t.Fatal("want an error without retries")
+	}
+	if n := requests.Swap(0); n != 1 {
+		t.Errorf("want a single attempt, got %d", n)
+	}
+}
+
+func TestRetryClientError(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket", WithRetries(3), WithBackoff(&ConstantBackoff{Delay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	// a 4xx isn't retried
+	_, err = client.GetBlockByNumber(context.Background(), 100)
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusBadRequest {
+		t.Fatalf("want a 400 status error, got %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("want a single attempt, got %d", n)
+	}
+}
+
+func TestRetryMaxElapsed(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket", WithRetries(10), WithRetryMaxElapsed(2500*time.Millisecond), WithBackoff(&ConstantBackoff{Delay: time.Second}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	clock := new(mclock.Simulated)
+	client.clock = clock
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.GetBlockByNumber(context.Background(), 100)
+		errc <- err
+	}()
+	// two retries fit in the max elapsed time, the third would start past it
+	for i := 0; i < 2; i++ {
+		clock.WaitForTimers(1)
+		clock.Run(time.Second)
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("want an error once the max elapsed time is reached")
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("want 3 attempts, got %d", n)
+	}
+}
+
+func TestArchiverUnavailable(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	// server errors are reported unavailable, keeping their status matchable
+	_, err = client.GetBlockByNumber(context.Background(), 100)
+	var statusErr *StatusError
+	if !errors.Is(err, ErrArchiverUnavailable) || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want %v with status 503, got %v", ErrArchiverUnavailable, err)
+	}
+	if _, err := client.GetBundleName(context.Background(), 100); !errors.Is(err, ErrArchiverUnavailable) {
+		t.Errorf("want %v for the bundle name, got %v", ErrArchiverUnavailable, err)
+	}
+	// client errors are not
+	status.Store(http.StatusBadRequest)
+	if _, err := client.GetBlockByNumber(context.Background(), 100); err == nil || errors.Is(err, ErrArchiverUnavailable) {
+		t.Errorf("want a client error not matching %v, got %v", ErrArchiverUnavailable, err)
+	}
+	// neither are the blocks the archiver doesn't serve
+	empty := httptest.NewServer(newTestArchiver())
+	defer empty.Close()
+	client, err = New(empty.URL, empty.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.GetBlocksByRange(context.Background(), 100, 101); !errors.Is(err, ErrBlockNotFound) || !errors.Is(err, ethereum.NotFound) {
+		t.Errorf("want %v matching ethereum.NotFound, got %v", ErrBlockNotFound, err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 1)...)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		archiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket", WithRetries(1), WithBackoff(&ConstantBackoff{Delay: time.Second}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	clock := new(mclock.Simulated)
+	client.clock = clock
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.GetBlockByNumber(context.Background(), 100)
+		errc <- err
+	}()
+	// the retry waits for the Retry-After delay rather than the shorter backoff
+	clock.WaitForTimers(1)
+	clock.Run(time.Second)
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("retried before the Retry-After delay, %d requests", n)
+	}
+	clock.Run(4 * time.Second)
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to get block after the rate limit: %v", err)
+	}
+
+	// once the retries are exhausted the rate limit is reported with its delay
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer limited.Close()
+	client, err = New(limited.URL, limited.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var rateLimit *RateLimitError
+	if _, err := client.GetBlockByNumber(context.Background(), 100); !errors.As(err, &rateLimit) || rateLimit.RetryAfter != 2*time.Second {
+		t.Fatalf("want rate limit error with a 2s delay, got %v", err)
+	}
+	if !errors.Is(rateLimit, ErrRateLimited) {
+		t.Errorf("want error matching %v", ErrRateLimited)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+		{"-1", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoffClock(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 1)...)
+	var failed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !failed.Swap(true) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		archiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket", WithRetries(1))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	clock := new(mclock.Simulated)
+	client.clock = clock
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.GetBlockByNumber(context.Background(), 100)
+		errc <- err
+	}()
+	// the retry waits for the backoff on the clock, not on the wall clock
+	clock.WaitForTimers(1)
+	select {
+	case err := <-errc:
+		t.Fatalf("request returned before the backoff elapsed: %v", err)
+	default:
+	}
+	clock.Run(DefaultBackoffBase)
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to get block after the retry: %v", err)
+	}
+}
+
+func TestBundleFetchCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a hung storage provider only returns once the request is cancelled
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	sp, _ := url.Parse(server.URL)
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.hc.Transport = bundleRouter{host: sp.Host, next: client.hc.Transport}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.GetBundleBlocks(ctx, "blocks_s0_e9"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want the caller deadline to cancel the fetch, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled fetch returned after %v", elapsed)
+	}
+}
+
+func TestJSONRPCError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"block not found"}}`))
+	}))
+	defer server.Close()
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx := context.Background()
+	calls := map[string]func() error{
+		"GetBlockByHash": func() error {
+			_, err := client.GetBlockByHash(ctx, common.Hash{1})
+			return err
+		},
+		"GetBlockByNumber": func() error {
+			_, err := client.GetBlockByNumber(ctx, 1)
+			return err
+		},
+		"GetLatestBlock": func() error {
+			_, err := client.GetLatestBlock(ctx)
+			return err
+		},
+		"GetBlockHeaderByNumber": func() error {
+			_, err := client.GetBlockHeaderByNumber(ctx, 1)
+			return err
+		},
+		"GetBlockHeaderByHash": func() error {
+			_, err := client.GetBlockHeaderByHash(ctx, common.Hash{1})
+			return err
+		},
+		"GetBundleBlocksByBlockNum": func() error {
+			_, err := client.GetBundleBlocksByBlockNum(ctx, 1)
+			return err
+		},
+		"GetRawTransactionByHash": func() error {
+			_, err := client.GetRawTransactionByHash(ctx, common.Hash{1})
+			return err
+		},
+	}
+	for name, call := range calls {
+		var rpcErr *JsonError
+		if err := call(); !errors.As(err, &rpcErr) || rpcErr.Code != -32000 || rpcErr.Message != "block not found" {
+			t.Errorf("%s: want the archiver rpc error, got %v", name, err)
+		}
+	}
+}
+
+func TestClientCloseConcurrent(t *testing.T) {
+	client, err := New("http://127.0.0.1", "http://127.0.0.1", "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- client.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("close failed: %v", err)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("closing the closed client failed: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package blockarchiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// FieldDiff is a field holding different values in two versions of the same block
+type FieldDiff struct {
+	Field string
+	A     string
+	B     string
+}
+
+// BlockDiff is the result of comparing the same block served by two block archivers
+type BlockDiff struct {
+	Number uint64
+	HashA  common.Hash
+	HashB  common.Hash
+	Fields []FieldDiff
+}
+
+// Equal reports whether both archivers served the same block
+func (d *BlockDiff) Equal() bool {
+	return d.HashA == d.HashB && len(d.Fields) == 0
+}
+
+// CompareBlock fetches the same block from two block archiver hosts and reports the fields which
+// differ between them. It is a diagnostic tool to detect divergent archivers, the caches are
+// neither read nor written.
+func (c *BlockArchiverService) CompareBlock(number uint64, hostA, hostB string) (*BlockDiff, error) {
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	blockA, err := c.fetchBlockFrom(ctx, hostA, number)
+	if err != nil {
+		return nil, fmt.Errorf("host %s: %w", hostA, err)
+	}
+	blockB, err := c.fetchBlockFrom(ctx, hostB, number)
+	if err != nil {
+		return nil, fmt.Errorf("host %s: %w", hostB, err)
+	}
+	diff := &BlockDiff{Number: number, HashA: blockA.Hash(), HashB: blockB.Hash()}
+
+	fieldsA, err := headerFields(blockA.Header())
+	if err != nil {
+		return nil, err
+	}
+	fieldsB, err := headerFields(blockB.Header())
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(fieldsA))
+	for name := range fieldsA {
+		names = append(names, name)
+	}
+	for name := range fieldsB {
+		if _, ok := fieldsA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fieldsA[name] != fieldsB[name] {
+			diff.Fields = append(diff.Fields, FieldDiff{Field: name, A: fieldsA[name], B: fieldsB[name]})
+		}
+	}
+	if a, b := blockA.TotalDifficulty, blockB.TotalDifficulty; (a == nil) != (b == nil) || (a != nil && a.Cmp(b) != 0) {
+		diff.Fields = append(diff.Fields, FieldDiff{Field: "totalDifficulty", A: a.String(), B: b.String()})
+	}
+	txsA, txsB := blockA.Transactions(), blockB.Transactions()
+	if len(txsA) != len(txsB) {
+		diff.Fields = append(diff.Fields, FieldDiff{Field: "transactions", A: fmt.Sprint(len(txsA)), B: fmt.Sprint(len(txsB))})
+	} else {
+		for i := range txsA {
+			if txsA[i].Hash() != txsB[i].Hash() {
+				diff.Fields = append(diff.Fields, FieldDiff{Field: fmt.Sprintf("transactions[%d]", i), A: txsA[i].Hash().Hex(), B: txsB[i].Hash().Hex()})
+			}
+		}
+	}
+	return diff, nil
+}
+
+// fetchBlockFrom fetches and converts a block from the given block archiver host
+func (c *BlockArchiverService) fetchBlockFrom(ctx context.Context, host string, number uint64) (*GeneralBlock, error) {
+	block, err := c.client.GetBlockByNumber(WithHost(ctx, host), number)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("%w: number %d", ErrBlockNotFound, number)
+	}
+	return convertBlock(block)
+}
+
+// headerFields returns the JSON encoded fields of a header keyed by name
+func headerFields(header *types.Header) (map[string]string, error) {
+	enc, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(enc, &raw); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(raw))
+	for name, value := range raw {
+		fields[name] = string(value)
+	}
+	return fields, nil
+}
@@ -1,12 +1,222 @@
 package blockarchiver
 
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DefaultWarmUpTimeout bounds the connection warm-up done at startup
+const DefaultWarmUpTimeout = 5 * time.Second
+
+// DefaultCacheStatsInterval is the period of the cache stats log of the default config
+const DefaultCacheStatsInterval = time.Minute
+
 type BlockArchiverConfig struct {
 	RPCAddress     string
 	SPAddress      string
 	BucketName     string
 	BlockCacheSize int64
+	// MaxBlockCacheSize enables the adaptive block caches when above BlockCacheSize, they start at
+	// BlockCacheSize entries and grow up to MaxBlockCacheSize while the hit rate is low
+	MaxBlockCacheSize int64
+	// BodyCacheBytes bounds the body cache by the total size of the decoded bodies on top of the
+	// BlockCacheSize entry limit, zero disables the byte limit
+	BodyCacheBytes uint64
+	// MaxInflightRequests bounds the cache misses served at the same time, the excess callers fail
+	// with ErrOverloaded. Zero means DefaultMaxInflightRequests
+	MaxInflightRequests int
+	// DialTimeout bounds the connection establishment to the block archiver, zero means
+	// DefaultDialTimeout
+	DialTimeout time.Duration
+	// HTTPTimeout bounds every HTTP exchange with the archiver, zero means DefaultHTTPTimeout
+	HTTPTimeout time.Duration
+	// MaxResponseBytes bounds the size of a response body, bundles included, zero means
+	// DefaultMaxResponseBytes
+	MaxResponseBytes int64
+	// Compression asks the archiver for gzip encoded responses, see WithCompression
+	Compression bool
+	// MaxConnsPerHost and IdleConnTimeout tune the connection pool, zero keeps the defaults
+	MaxConnsPerHost int
+	IdleConnTimeout time.Duration
+	// RPCRequestTimeout and RESTRequestTimeout bound the requests sent to the JSON-RPC and the
+	// bundle name REST endpoints respectively, zero only applies the caller's deadline
+	RPCRequestTimeout  time.Duration
+	RESTRequestTimeout time.Duration
+	// GetBlockTimeout bounds the time a request waits for the bundle of its block while another
+	// request fetches it, the request fails once it elapses. It is a single wait woken up as soon
+	// as the fetch is done, so it is the worst case added to the fetch. Zero means GetBlockTimeout
+	GetBlockTimeout time.Duration
+	// BundleDecodeTimeout bounds the decoding of a downloaded bundle, zero disables the bound
+	BundleDecodeTimeout time.Duration
+	// MaxBlockRange bounds the number of blocks of a GetBlocksByRange call, zero means
+	// DefaultMaxBlockRange
+	MaxBlockRange uint64
+	// Retries is the number of times a failed JSON-RPC request is retried, zero disables retries
+	Retries int
+	// RetryMaxElapsed bounds the time spent retrying a failed JSON-RPC request, zero for no bound
+	RetryMaxElapsed time.Duration
+	// MethodTimeouts bounds the calls of specific client methods by name, taking precedence over
+	// the request timeouts above, see WithMethodTimeouts
+	MethodTimeouts map[string]time.Duration
+	// SenderCacheSize is the number of recovered transaction senders cached by transaction hash,
+	// the senders of the fetched blocks are only recovered during the conversion if non-zero
+	SenderCacheSize int
+	// ExpectedChainID is checked against the chain id reported by the archiver when the service
+	// is created, which fails on a mismatch. Nil skips the check
+	ExpectedChainID *big.Int
+	// TrustedCheckpoints are the known hashes of some blocks, a fetched block not matching its
+	// checkpoint is rejected
+	TrustedCheckpoints map[uint64]common.Hash
+	// EmptyBundleRetries is the number of times a bundle holding no blocks is fetched again before
+	// the request fails with ErrMalformedBundle
+	EmptyBundleRetries int
+	// SkipCompletenessCheck accepts the fetched bundles not holding exactly the blocks of their
+	// range, saving the check done on every bundle fetch
+	SkipCompletenessCheck bool
+	// VerifyTxRoot checks the transactions and withdrawals of every fetched block against the roots
+	// of its header. It's off by default, saving the trie hashing on the throughput-critical paths
+	VerifyTxRoot bool
+	// VerifyHashes checks that every block converted from a fetched bundle hashes to the hash
+	// reported by the archiver, the headers fetched alone are always checked
+	VerifyHashes bool
+	// BundleResultTTL keeps the blocks of a fetched bundle in memory for the given time, so the
+	// requests for the same bundle right after the fetch don't download it again. Zero disables it
+	BundleResultTTL time.Duration
+	// NotFoundTTL is how long a block the archiver doesn't serve, e.g. one beyond its head, is
+	// reported missing without asking the archiver again. Keep it short, a second or two, so the
+	// blocks archived meanwhile aren't masked. Zero disables it
+	NotFoundTTL time.Duration
+	// LatestBlockTTL is how long a fetched latest block is returned without asking the archiver
+	// again, zero means BlockInterval and a negative TTL fetches the latest block on every call
+	LatestBlockTTL time.Duration
+	// VerifyContinuity checks that every fetched block links to its parent by hash and has a
+	// timestamp not before its parent. The check of the first block of a bundle is skipped when
+	// its parent isn't cached
+	VerifyContinuity bool
+	// StrictContinuity enables VerifyContinuity and fetches the uncached parent of the first block
+	// of a bundle instead of skipping its check
+	StrictContinuity bool
+	// DiskCacheDir keeps the fetched bundles on disk in the given directory, empty disables it
+	DiskCacheDir string
+	// DiskCacheFormat is the serialization of the disk cache, DiskCacheRLP if empty
+	DiskCacheFormat DiskCacheFormat
+	// RPCMethods renames the JSON-RPC methods called on the block archiver, the empty fields keep
+	// the names of DefaultRPCMethods
+	RPCMethods RPCMethods
+	// DiagnosticHeaders are the response headers logged at debug level for every request, see
+	// WithDiagnosticHeaders. Empty disables the capture
+	DiagnosticHeaders []string
+	// RequestMetrics records the latency, response size and status of every request by method,
+	// see MetricsObserver
+	RequestMetrics bool
+	// Tenants is the allowlist of tenant tags requests can be accounted to, see WithTenant
+	Tenants []string
+	// CacheStatsInterval is the period of the cache stats log, zero or negative disables it
+	CacheStatsInterval time.Duration
+	// WarmUp pre-dials the block archiver at startup to save the handshake on the first request
+	WarmUp bool
 }
 
 var DefaultBlockArchiverConfig = BlockArchiverConfig{
-	BlockCacheSize: 50000,
+	BlockCacheSize:     50000,
+	CacheStatsInterval: DefaultCacheStatsInterval,
+}
+
+// Validate checks that the archiver and storage provider addresses are http(s) urls and that the
+// block cache sizes are positive and fit in an int
+func (c *BlockArchiverConfig) Validate() error {
+	if c.RPCAddress == "" {
+		return errors.New("block archiver rpc address is empty")
+	}
+	for name, address := range map[string]string{"rpc": c.RPCAddress, "storage provider": c.SPAddress} {
+		u, err := url.Parse(address)
+		if err != nil {
+			return fmt.Errorf("invalid block archiver %s address %q: %w", name, address, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid block archiver %s address %q, want an http(s) url", name, address)
+		}
+	}
+	if c.BlockCacheSize <= 0 || c.BlockCacheSize > math.MaxInt {
+		return fmt.Errorf("invalid block cache size %d", c.BlockCacheSize)
+	}
+	if c.MaxBlockCacheSize > math.MaxInt {
+		return fmt.Errorf("invalid max block cache size %d", c.MaxBlockCacheSize)
+	}
+	return nil
+}
+
+// CacheCapacity returns the number of entries the block caches must be created with, the upper
+// bound of the adaptive caches if enabled
+func (c *BlockArchiverConfig) CacheCapacity() int64 {
+	if c.MaxBlockCacheSize > c.BlockCacheSize {
+		return c.MaxBlockCacheSize
+	}
+	return c.BlockCacheSize
+}
+
+// ClientOptions returns the client options derived from the config
+func (c *BlockArchiverConfig) ClientOptions() []Option {
+	var opts []Option
+	if c.WarmUp {
+		opts = append(opts, WithWarmUp(DefaultWarmUpTimeout))
+	}
+	if c.DialTimeout > 0 {
+		opts = append(opts, WithDialTimeout(c.DialTimeout))
+	}
+	if c.HTTPTimeout > 0 {
+		opts = append(opts, WithTimeout(c.HTTPTimeout))
+	}
+	if c.MaxResponseBytes > 0 {
+		opts = append(opts, WithMaxResponseBytes(c.MaxResponseBytes))
+	}
+	if c.Compression {
+		opts = append(opts, WithCompression(true))
+	}
+	if c.MaxConnsPerHost > 0 {
+		opts = append(opts, WithMaxConnsPerHost(c.MaxConnsPerHost))
+	}
+	if c.IdleConnTimeout > 0 {
+		opts = append(opts, WithIdleConnTimeout(c.IdleConnTimeout))
+	}
+	if c.RPCRequestTimeout > 0 {
+		opts = append(opts, WithRPCPolicy(EndpointPolicy{Timeout: c.RPCRequestTimeout}))
+	}
+	if c.RESTRequestTimeout > 0 {
+		opts = append(opts, WithRESTPolicy(EndpointPolicy{Timeout: c.RESTRequestTimeout}))
+	}
+	if c.BundleDecodeTimeout > 0 {
+		opts = append(opts, WithDecodeTimeout(c.BundleDecodeTimeout))
+	}
+	if c.MaxBlockRange > 0 {
+		opts = append(opts, WithMaxBlockRange(c.MaxBlockRange))
+	}
+	if c.Retries > 0 {
+		opts = append(opts, WithRetries(c.Retries))
+	}
+	if c.RetryMaxElapsed > 0 {
+		opts = append(opts, WithRetryMaxElapsed(c.RetryMaxElapsed))
+	}
+	if len(c.MethodTimeouts) > 0 {
+		opts = append(opts, WithMethodTimeouts(c.MethodTimeouts))
+	}
+	if c.RPCMethods != (RPCMethods{}) {
+		opts = append(opts, WithRPCMethods(c.RPCMethods))
+	}
+	if len(c.DiagnosticHeaders) > 0 {
+		opts = append(opts, WithDiagnosticHeaders(c.DiagnosticHeaders...))
+	}
+	if c.RequestMetrics {
+		opts = append(opts, WithObserver(MetricsObserver{}))
+	}
+	if len(c.Tenants) > 0 {
+		opts = append(opts, WithTenants(c.Tenants...))
+	}
+	return opts
 }
@@ -0,0 +1,29 @@
+package blockarchiver
+
+import "testing"
+
+func TestValidateConfig(t *testing.T) {
+	valid := BlockArchiverConfig{RPCAddress: "http://127.0.0.1:8545", SPAddress: "https://sp.example.com", BucketName: "bucket", BlockCacheSize: 100}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+	tests := []struct {
+		name   string
+		modify func(*BlockArchiverConfig)
+	}{
+		{"empty rpc address", func(c *BlockArchiverConfig) { c.RPCAddress = "" }},
+		{"rpc address without scheme", func(c *BlockArchiverConfig) { c.RPCAddress = "127.0.0.1:8545" }},
+		{"rpc address without host", func(c *BlockArchiverConfig) { c.RPCAddress = "http://" }},
+		{"malformed sp address", func(c *BlockArchiverConfig) { c.SPAddress = "http://[::1" }},
+		{"empty sp address", func(c *BlockArchiverConfig) { c.SPAddress = "" }},
+		{"zero cache size", func(c *BlockArchiverConfig) { c.BlockCacheSize = 0 }},
+		{"negative cache size", func(c *BlockArchiverConfig) { c.BlockCacheSize = -1 }},
+	}
+	for _, tt := range tests {
+		config := valid
+		tt.modify(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package blockarchiver
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// checkContinuity checks that a fetched header links to its parent by hash and doesn't go back in
+// time. parent is the previous block of the same bundle, nil for the first block of a bundle, in
+// which case the parent is read from the caches. A parent missing from the caches, i.e. in another
+// bundle, is fetched with strict continuity, failing the check if it can't be fetched, and the
+// check is skipped otherwise. The genesis block has no parent and is never checked.
+func (c *BlockArchiverService) checkContinuity(parent, header *types.Header) error {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	if parent == nil {
+		var err error
+		if parent, err = c.continuityParent(number - 1); err != nil {
+			return err
+		}
+		if parent == nil {
+			continuitySkippedMeter.Mark(1)
+			return nil
+		}
+	}
+	continuityCheckedMeter.Mark(1)
+	if header.ParentHash != parent.Hash() {
+		return fmt.Errorf("block %d parent hash %s doesn't match block %d hash %s", number, header.ParentHash, parent.Number, parent.Hash())
+	}
+	if header.Time < parent.Time {
+		return fmt.Errorf("block %d timestamp %d is before its parent timestamp %d", number, header.Time, parent.Time)
+	}
+	return nil
+}
+
+// continuityParent returns the header by number from the caches, fetching it with strict
+// continuity. It returns nil without strict continuity if the header isn't cached.
+func (c *BlockArchiverService) continuityParent(number uint64) (*types.Header, error) {
+	if hash, found := c.hashCache.Get(number); found {
+		if header, found := c.headerCache.Get(hash); found {
+			return header, nil
+		}
+	}
+	if !c.strictContinuity {
+		return nil, nil
+	}
+	hash, err := c.GetBlockHashByNumber(number)
+	if err != nil {
+		log.Error("failed to fetch parent for the continuity check", "number", number, "err", err)
+		return nil, fmt.Errorf("parent block %d unavailable for the continuity check: %w", number, err)
+	}
+	header, found := c.headerCache.Get(hash)
+	if !found {
+		return nil, fmt.Errorf("parent block %d unavailable for the continuity check", number)
+	}
+	return header, nil
+}
@@ -0,0 +1,86 @@
+package blockarchiver
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// headerFetched reports whether the header of the block by number was requested from the archiver
+func headerFetched(archiver *testArchiver, number uint64) bool {
+	for _, params := range archiver.callParams("eth_getBlockByNumber") {
+		if len(params) > 0 && params[0] == Int64ToHex(int64(number)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestContinuityBundleBoundary(t *testing.T) {
+	chain := newLinkedTestChain(t, 100, 20)
+	// the parent of the bundle [110, 119] served alone doesn't match the bundle
+	header, err := convertHeader(chain[9])
+	if err != nil {
+		t.Fatalf("failed to convert header: %v", err)
+	}
+	header.Extra = []byte("forked block")
+	forked := newTestArchiver(chain...)
+	forked.blocks[109] = toWireBlock(header, nil)
+
+	for _, test := range []struct {
+		name     string
+		archiver *testArchiver
+		strict   bool
+		fail     bool
+	}{
+		{name: "skipped", archiver: newTestArchiver(chain...)},
+		{name: "skipped forked", archiver: forked},
+		{name: "strict", archiver: newTestArchiver(chain...), strict: true},
+		{name: "strict forked", archiver: forked, strict: true, fail: true},
+	} {
+		service := newTestService(t, test.archiver)
+		service.verifyContinuity, service.strictContinuity = true, test.strict
+		serveBundles(service)
+
+		_, _, err := service.GetBlockByNumber(115)
+		if (err != nil) != test.fail {
+			t.Errorf("%s: want failure %v, got %v", test.name, test.fail, err)
+		}
+		// the parent in the previous bundle is only fetched in strict mode
+		if fetched := headerFetched(test.archiver, 109); fetched != test.strict {
+			t.Errorf("%s: want parent fetched %v, got %v", test.name, test.strict, fetched)
+		}
+	}
+}
+
+func TestContinuityCachedParent(t *testing.T) {
+	chain := newLinkedTestChain(t, 100, 20)
+	archiver := newTestArchiver(chain...)
+	service := newTestService(t, archiver)
+	service.verifyContinuity = true
+	serveBundles(service)
+
+	// the parent cached with the previous bundle is checked without being fetched
+	parent, err := convertBlock(chain[9])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(parent.Block)
+	if _, _, err := service.GetBlockByNumber(115); err != nil {
+		t.Fatalf("failed to get block with a cached parent: %v", err)
+	}
+	if headerFetched(archiver, 109) {
+		t.Error("cached parent was fetched")
+	}
+
+	// a broken link inside a bundle always fails
+	header, err := convertHeader(chain[3])
+	if err != nil {
+		t.Fatalf("failed to convert header: %v", err)
+	}
+	header.ParentHash = common.Hash{0x01}
+	archiver.blocks[103] = toWireBlock(header, nil)
+	if _, _, err := service.GetBlockByNumber(105); err == nil {
+		t.Error("want an error for a broken link inside a bundle")
+	}
+}
@@ -2,12 +2,14 @@ package blockarchiver
 
 import (
 	"errors"
+	"fmt"
 	"math/big"
 	"strconv"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
 	"github.com/holiman/uint256"
 )
 
@@ -41,6 +43,240 @@ func HexToBigInt(hexStr string) (*big.Int, error) {
 
 // convertBlock converts a block to a general block
 func convertBlock(block *Block) (*GeneralBlock, error) {
+	if block == nil {
+		return nil, errors.New("block is nil")
+	}
+	header, err := convertHeader(block)
+	if err != nil {
+		return nil, err
+	}
+	// the total difficulty is left nil when the archiver doesn't report it, so it is never
+	// mistaken for a real zero
+	var totalDifficulty *big.Int
+	if block.TotalDifficulty != "" {
+		totalDifficulty, err = HexToBigInt(block.TotalDifficulty)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := checkUncles(header, block.Uncles); err != nil {
+		return nil, err
+	}
+	txs, err := convertTransactions(block.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, err := convertWithdrawals(header, block.Withdrawals)
+	if err != nil {
+		return nil, err
+	}
+	newBlock := types.NewBlockWithHeader(header).WithBody(txs, make([]*types.Header, 0))
+	if withdrawals != nil {
+		newBlock = newBlock.WithWithdrawals(withdrawals)
+	}
+	return &GeneralBlock{
+		Block:           newBlock,
+		TotalDifficulty: totalDifficulty,
+	}, nil
+}
+
+// checkUncles validates the uncle hashes of a block against its Sha3Uncles. BSC has no uncles
+// and the archiver only serves their hashes, so a block claiming uncles can't be rebuilt with a
+// matching body and is rejected rather than silently returned without them.
+func checkUncles(header *types.Header, uncles []string) error {
+	empty := header.UncleHash == types.EmptyUncleHash
+	switch {
+	case len(uncles) > 0 && empty:
+		return fmt.Errorf("block %v lists %d uncles but has the empty uncles hash", header.Number, len(uncles))
+	case len(uncles) == 0 && !empty:
+		return fmt.Errorf("block %v has no uncles but uncles hash %s", header.Number, header.UncleHash)
+	case len(uncles) > 0:
+		return fmt.Errorf("block %v has %d uncles, uncle headers are not served by the archiver", header.Number, len(uncles))
+	}
+	return nil
+}
+
+// convertReceipts converts the receipts of a block, the bloom filters are recomputed from the logs
+func convertReceipts(receipts []*Receipt) ([]*types.Receipt, error) {
+	result := make([]*types.Receipt, 0, len(receipts))
+	for _, r := range receipts {
+		receipt := &types.Receipt{
+			TxHash:    common.HexToHash(r.TransactionHash),
+			BlockHash: common.HexToHash(r.BlockHash),
+		}
+		if r.Type != "" {
+			typ, err := HexToUint64(r.Type)
+			if err != nil {
+				return nil, err
+			}
+			receipt.Type = uint8(typ)
+		}
+		if r.Root != "" {
+			receipt.PostState = common.FromHex(r.Root)
+		} else {
+			status, err := HexToUint64(r.Status)
+			if err != nil {
+				return nil, err
+			}
+			receipt.Status = status
+		}
+		var err error
+		if receipt.CumulativeGasUsed, err = HexToUint64(r.CumulativeGasUsed); err != nil {
+			return nil, err
+		}
+		if receipt.GasUsed, err = HexToUint64(r.GasUsed); err != nil {
+			return nil, err
+		}
+		if r.EffectiveGasPrice != "" {
+			if receipt.EffectiveGasPrice, err = HexToBigInt(r.EffectiveGasPrice); err != nil {
+				return nil, err
+			}
+		}
+		if receipt.BlockNumber, err = HexToBigInt(r.BlockNumber); err != nil {
+			return nil, err
+		}
+		index, err := HexToUint64(r.TransactionIndex)
+		if err != nil {
+			return nil, err
+		}
+		receipt.TransactionIndex = uint(index)
+		if r.ContractAddress != "" {
+			receipt.ContractAddress = common.HexToAddress(r.ContractAddress)
+		}
+		for _, l := range r.Logs {
+			data, err := hexutil.Decode(l.Data)
+			if err != nil {
+				return nil, err
+			}
+			logIndex, err := HexToUint64(l.LogIndex)
+			if err != nil {
+				return nil, err
+			}
+			entry := &types.Log{
+				Address:     common.HexToAddress(l.Address),
+				Topics:      make([]common.Hash, 0, len(l.Topics)),
+				Data:        data,
+				BlockNumber: receipt.BlockNumber.Uint64(),
+				TxHash:      receipt.TxHash,
+				TxIndex:     receipt.TransactionIndex,
+				BlockHash:   receipt.BlockHash,
+				Index:       uint(logIndex),
+			}
+			for _, topic := range l.Topics {
+				entry.Topics = append(entry.Topics, common.HexToHash(topic))
+			}
+			receipt.Logs = append(receipt.Logs, entry)
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		result = append(result, receipt)
+	}
+	return result, nil
+}
+
+// convertLog converts a log returned by eth_getLogs, which carries its position in the chain
+func convertLog(l *Log) (types.Log, error) {
+	data, err := hexutil.Decode(l.Data)
+	if err != nil {
+		return types.Log{}, err
+	}
+	entry := types.Log{
+		Address:   common.HexToAddress(l.Address),
+		Topics:    make([]common.Hash, 0, len(l.Topics)),
+		Data:      data,
+		TxHash:    common.HexToHash(l.TransactionHash),
+		BlockHash: common.HexToHash(l.BlockHash),
+		Removed:   l.Removed,
+	}
+	for _, topic := range l.Topics {
+		entry.Topics = append(entry.Topics, common.HexToHash(topic))
+	}
+	if entry.BlockNumber, err = HexToUint64(l.BlockNumber); err != nil {
+		return types.Log{}, err
+	}
+	txIndex, err := HexToUint64(l.TransactionIndex)
+	if err != nil {
+		return types.Log{}, err
+	}
+	entry.TxIndex = uint(txIndex)
+	index, err := HexToUint64(l.LogIndex)
+	if err != nil {
+		return types.Log{}, err
+	}
+	entry.Index = uint(index)
+	return entry, nil
+}
+
+// verifyReceiptsRoot checks that the receipts of a block hash to the ReceiptHash of its header
+func verifyReceiptsRoot(header *types.Header, receipts []*types.Receipt) error {
+	if root := types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil)); root != header.ReceiptHash {
+		return fmt.Errorf("block %v receipts root mismatch, header %s, computed %s", header.Number, header.ReceiptHash, root)
+	}
+	return nil
+}
+
+// verifyTransactionsRoot checks that the transactions of a block hash to the TransactionsRoot of
+// its header. An empty block converts to an empty list, which hashes to types.EmptyTxsHash.
+func verifyTransactionsRoot(block *types.Block) error {
+	if root := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); root != block.TxHash() {
+		return fmt.Errorf("block %d transactions root mismatch, header %s, computed %s", block.NumberU64(), block.TxHash(), root)
+	}
+	return nil
+}
+
+// verifyWithdrawalsRoot checks that the withdrawals of a post Shanghai block hash to the
+// WithdrawalsRoot of its header, the blocks without withdrawals root pass.
+func verifyWithdrawalsRoot(block *types.Block) error {
+	want := block.Header().WithdrawalsHash
+	if want == nil {
+		return nil
+	}
+	if root := types.DeriveSha(block.Withdrawals(), trie.NewStackTrie(nil)); root != *want {
+		return fmt.Errorf("block %d withdrawals root mismatch, header %s, computed %s", block.NumberU64(), *want, root)
+	}
+	return nil
+}
+
+// verifyBodyRoots checks the transactions and withdrawals of a converted block against the roots
+// of its header, catching the archivers dropping or reordering them.
+func verifyBodyRoots(block *types.Block) error {
+	if err := verifyTransactionsRoot(block); err != nil {
+		return err
+	}
+	return verifyWithdrawalsRoot(block)
+}
+
+// convertWithdrawals converts the withdrawals of a block. They are nil before Shanghai, i.e. when
+// the header has no withdrawals root, and non-nil, possibly empty, afterwards.
+func convertWithdrawals(header *types.Header, withdrawals []Withdrawal) ([]*types.Withdrawal, error) {
+	if header.WithdrawalsHash == nil {
+		return nil, nil
+	}
+	result := make([]*types.Withdrawal, 0, len(withdrawals))
+	for _, w := range withdrawals {
+		index, err := HexToUint64(w.Index)
+		if err != nil {
+			return nil, err
+		}
+		validator, err := HexToUint64(w.ValidatorIndex)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := HexToUint64(w.Amount)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &types.Withdrawal{
+			Index:     index,
+			Validator: validator,
+			Address:   common.HexToAddress(w.Address),
+			Amount:    amount,
+		})
+	}
+	return result, nil
+}
+
+// convertHeader converts the header fields of a block, the transactions are left untouched
+func convertHeader(block *Block) (*types.Header, error) {
 	if block == nil {
 		return nil, errors.New("block is nil")
 	}
@@ -68,14 +304,33 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 	if err != nil {
 		return nil, err
 	}
-	totalDifficulty, err := HexToBigInt(block.TotalDifficulty)
+	parentHash, err := parseHash(block, "parentHash", block.ParentHash)
+	if err != nil {
+		return nil, err
+	}
+	stateRoot, err := parseHash(block, "stateRoot", block.StateRoot)
+	if err != nil {
+		return nil, err
+	}
+	txRoot, err := parseHash(block, "transactionsRoot", block.TransactionsRoot)
+	if err != nil {
+		return nil, err
+	}
+	receiptsRoot, err := parseHash(block, "receiptsRoot", block.ReceiptsRoot)
+	if err != nil {
+		return nil, err
+	}
+	miner, err := parseAddress(block, "miner", block.Miner)
 	if err != nil {
 		return nil, err
 	}
 
 	var withdrawals *common.Hash
 	if block.WithdrawalsRoot != "" {
-		hash := common.HexToHash(block.WithdrawalsRoot)
+		hash, err := parseHash(block, "withdrawalsRoot", block.WithdrawalsRoot)
+		if err != nil {
+			return nil, err
+		}
 		withdrawals = &hash
 	}
 
@@ -109,12 +364,12 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 	}
 
 	header := &types.Header{
-		ParentHash:       common.HexToHash(block.ParentHash),
+		ParentHash:       parentHash,
 		UncleHash:        common.HexToHash(block.Sha3Uncles),
-		Coinbase:         common.HexToAddress(block.Miner),
-		Root:             common.HexToHash(block.StateRoot),
-		TxHash:           common.HexToHash(block.TransactionsRoot),
-		ReceiptHash:      common.HexToHash(block.ReceiptsRoot),
+		Coinbase:         miner,
+		Root:             stateRoot,
+		TxHash:           txRoot,
+		ReceiptHash:      receiptsRoot,
 		Bloom:            types.BytesToBloom(hexutil.MustDecode(block.LogsBloom)),
 		Difficulty:       diffculty,
 		Number:           number,
@@ -132,9 +387,59 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 	if baseFeePerGas != nil {
 		header.BaseFee = baseFeePerGas
 	}
+	return header, nil
+}
 
+// parseHash parses a hash field of a block. Unlike common.HexToHash, which silently pads or
+// truncates its input, it requires exactly 32 hex encoded bytes.
+func parseHash(block *Block, field, value string) (common.Hash, error) {
+	b, err := hexutil.Decode(value)
+	if err != nil || len(b) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("block %s: malformed %s %q", block.Number, field, value)
+	}
+	return common.BytesToHash(b), nil
+}
+
+// parseAddress parses an address field of a block, whatever the case of its hex digits, requiring
+// exactly 20 hex encoded bytes
+func parseAddress(block *Block, field, value string) (common.Address, error) {
+	if !common.IsHexAddress(value) {
+		return common.Address{}, fmt.Errorf("block %s: malformed %s %q", block.Number, field, value)
+	}
+	return common.HexToAddress(value), nil
+}
+
+// convertVerifiedHeader converts the header fields of a block and checks that the result hashes
+// to the hash reported by the archiver, so a lossy conversion never ends up in the caches
+func convertVerifiedHeader(block *Block) (*types.Header, error) {
+	header, err := convertHeader(block)
+	if err != nil {
+		return nil, err
+	}
+	if want, got := common.HexToHash(block.Hash), header.Hash(); want != got {
+		return nil, fmt.Errorf("block %s hash mismatch, archiver reported %s, computed %s", block.Number, want, got)
+	}
+	return header, nil
+}
+
+// ToTransaction converts the wire transaction to a go-ethereum transaction, checking that the
+// converted transaction has the hash served by the archiver
+func (tx *Transaction) ToTransaction() (*types.Transaction, error) {
+	txs, err := convertTransactions([]Transaction{*tx})
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) == 0 {
+		return nil, fmt.Errorf("unsupported transaction type %q", tx.Type)
+	}
+	return txs[0], nil
+}
+
+// convertTransactions converts the transactions of a block
+func convertTransactions(transactions []Transaction) ([]*types.Transaction, error) {
 	txs := make([]*types.Transaction, 0)
-	for _, tx := range block.Transactions {
+	for _, tx := range transactions {
+		before := len(txs)
 		nonce, err := HexToUint64(tx.Nonce)
 		if err != nil {
 			return nil, err
@@ -169,8 +474,14 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 			return nil, err
 		}
 		input := hexutil.MustDecode(tx.Input)
-		switch tx.Type {
-		case "0x0":
+		// the type is parsed rather than compared as a string, since archivers may encode it
+		// with leading zeros or in upper case
+		txType, err := HexToUint64(tx.Type)
+		if err != nil {
+			return nil, err
+		}
+		switch txType {
+		case types.LegacyTxType:
 			// create a new transaction
 			legacyTx := &types.LegacyTx{
 				Nonce:    nonce,
@@ -188,7 +499,7 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 			}
 			txn := types.NewTx(legacyTx)
 			txs = append(txs, txn)
-		case "0x1":
+		case types.AccessListTxType:
 			chainId, err := HexToBigInt(tx.ChainId)
 			if err != nil {
 				return nil, err
@@ -219,7 +530,7 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 				S:          s,
 			})
 			txs = append(txs, txn)
-		case "0x2":
+		case types.DynamicFeeTxType:
 			chainId, err := HexToBigInt(tx.ChainId)
 			if err != nil {
 				return nil, err
@@ -259,23 +570,29 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 				S:          s,
 			})
 			txs = append(txs, txn)
-		case "0x3":
-			chainId, err := HexToUint64(tx.ChainId)
-			if err != nil {
-				return nil, err
+		case types.BlobTxType:
+			// blob transactions can't create contracts
+			if toAddr == nil {
+				return nil, fmt.Errorf("blob transaction %s without recipient", tx.Hash)
 			}
-			gasTipCap, err := HexToUint64(tx.MaxPriorityFeePerGas)
-			if err != nil {
-				return nil, err
-			}
-			gasFeeCap, err := HexToUint64(tx.MaxFeePerGas)
-			if err != nil {
-				return nil, err
-			}
-			maxFeePerBlobGas, err := HexToBigInt(tx.MaxFeePerBlobGas)
-			if err != nil {
-				return nil, err
+			var fields [5]*uint256.Int
+			for i, field := range []struct{ name, value string }{
+				{"chainId", tx.ChainId},
+				{"maxPriorityFeePerGas", tx.MaxPriorityFeePerGas},
+				{"maxFeePerGas", tx.MaxFeePerGas},
+				{"maxFeePerBlobGas", tx.MaxFeePerBlobGas},
+				{"value", tx.Value},
+			} {
+				value, err := HexToBigInt(field.value)
+				if err != nil {
+					return nil, err
+				}
+				var overflow bool
+				if fields[i], overflow = uint256.FromBig(value); overflow {
+					return nil, fmt.Errorf("blob transaction %s %s overflows 256 bits", tx.Hash, field.name)
+				}
 			}
+			chainId, gasTipCap, gasFeeCap, maxFeePerBlobGas, value := fields[0], fields[1], fields[2], fields[3], fields[4]
 
 			var accessList types.AccessList
 			for _, access := range tx.AccessList {
@@ -295,30 +612,27 @@ func convertBlock(block *Block) (*GeneralBlock, error) {
 				blobHashes = append(blobHashes, blobHash)
 			}
 			transaction := types.NewTx(&types.BlobTx{
-				ChainID:    uint256.NewInt(chainId),
+				ChainID:    chainId,
 				Nonce:      nonce,
-				GasTipCap:  uint256.NewInt(gasTipCap),
-				GasFeeCap:  uint256.NewInt(gasFeeCap),
+				GasTipCap:  gasTipCap,
+				GasFeeCap:  gasFeeCap,
 				Gas:        gas,
 				To:         *toAddr,
-				Value:      uint256.NewInt(val.Uint64()),
+				Value:      value,
 				Data:       input,
 				AccessList: accessList,
 				V:          uint256.MustFromBig(v),
 				R:          uint256.MustFromBig(r),
 				S:          uint256.MustFromBig(s),
-				BlobFeeCap: uint256.NewInt(maxFeePerBlobGas.Uint64()),
+				BlobFeeCap: maxFeePerBlobGas,
 				BlobHashes: blobHashes,
 			})
 			txs = append(txs, transaction)
 		}
+		// the hash commits to every field, a mismatch means the transaction was decoded wrongly
+		if n := len(txs); n > before && tx.Hash != "" && txs[n-1].Hash() != common.HexToHash(tx.Hash) {
+			return nil, fmt.Errorf("transaction %s hash mismatch, computed %s", tx.Hash, txs[n-1].Hash().Hex())
+		}
 	}
-	newBlock := types.NewBlockWithHeader(header).WithBody(txs, make([]*types.Header, 0))
-	if header.WithdrawalsHash != nil && *header.WithdrawalsHash == types.EmptyWithdrawalsHash {
-		newBlock = newBlock.WithWithdrawals(make([]*types.Withdrawal, 0))
-	}
-	return &GeneralBlock{
-		Block:           newBlock,
-		TotalDifficulty: totalDifficulty,
-	}, nil
+	return txs, nil
 }
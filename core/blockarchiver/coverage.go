@@ -0,0 +1,133 @@
+package blockarchiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/log"
+	"golang.org/x/sync/singleflight"
+)
+
+// coverageTTL is how long the coverage window of the archiver is trusted before it is fetched again
+const coverageTTL = 30 * time.Second
+
+// ErrNotArchived is matched by the errors returned for blocks outside the archiver's coverage
+var ErrNotArchived = errors.New("block not archived")
+
+// NotArchivedError is returned for a block outside the range of blocks served by the archiver
+type NotArchivedError struct {
+	Number   uint64
+	Earliest uint64
+	Latest   uint64
+}
+
+func (e *NotArchivedError) Error() string {
+	return fmt.Sprintf("block %d not archived, archiver serves blocks [%d, %d]", e.Number, e.Earliest, e.Latest)
+}
+
+func (e *NotArchivedError) Unwrap() error {
+	return ErrNotArchived
+}
+
+// GetCoverage returns the earliest and the latest block served by the block archiver
+func (c *Client) GetCoverage(ctx context.Context) (earliest, latest uint64, err error) {
+	ctx, done := c.methodContext(ctx, "GetCoverage")
+	defer done()
+	for tag, number := range map[string]*uint64{"earliest": &earliest, "latest": &latest} {
+		block, err := c.getBlockHeader(ctx, tag)
+		if err != nil {
+			return 0, 0, err
+		}
+		if block == nil {
+			return 0, 0, fmt.Errorf("%s %w", tag, ErrBlockNotFound)
+		}
+		if *number, err = HexToUint64(block.Number); err != nil {
+			return 0, 0, err
+		}
+	}
+	return earliest, latest, nil
+}
+
+// coverage caches the coverage window of the archiver for coverageTTL
+type coverage struct {
+	mu       sync.Mutex
+	earliest uint64
+	latest   uint64
+	known    bool
+	updated  mclock.AbsTime
+	// attempted is the time of the last refresh, failures the number of refreshes failed since
+	// the last successful one, a refresh isn't retried before coverageBackoff
+	attempted mclock.AbsTime
+	failures  int
+	refresh   singleflight.Group
+}
+
+// coverageBackoff returns how long a refresh waits after the given number of failed ones
+func coverageBackoff(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	backoff := BlockInterval << min(failures-1, 8)
+	return min(backoff, coverageTTL)
+}
+
+// checkCoverage fails with a *NotArchivedError if the block by number is outside the coverage of
+// the archiver. The window is refreshed in the background once older than coverageTTL, or than a
+// block interval for a block above it since the archiver keeps moving, and the stale window is
+// served meanwhile, except that a block above it is let through. The check is skipped until the
+// window is first fetched, the request then fails or succeeds on its own.
+func (c *BlockArchiverService) checkCoverage(number uint64) error {
+	c.coverage.mu.Lock()
+	now := c.clock.Now()
+	age := now.Sub(c.coverage.updated)
+	refresh := (!c.coverage.known || age > coverageTTL || (number > c.coverage.latest && age > BlockInterval)) &&
+		now.Sub(c.coverage.attempted) >= coverageBackoff(c.coverage.failures)
+	known := c.coverage.known
+	c.coverage.mu.Unlock()
+
+	if refresh {
+		// concurrent callers share a single refresh, they only wait for it without a window to serve
+		done := c.coverage.refresh.DoChan("coverage", c.refreshCoverage)
+		if !known {
+			<-done
+		}
+	}
+	c.coverage.mu.Lock()
+	defer c.coverage.mu.Unlock()
+
+	if !c.coverage.known {
+		return nil
+	}
+	stale := c.clock.Now().Sub(c.coverage.updated) > BlockInterval
+	if number < c.coverage.earliest || (number > c.coverage.latest && !stale) {
+		return &NotArchivedError{Number: number, Earliest: c.coverage.earliest, Latest: c.coverage.latest}
+	}
+	return nil
+}
+
+// refreshCoverage fetches the coverage window of the archiver, recording failed attempts so the
+// following refreshes back off
+func (c *BlockArchiverService) refreshCoverage() (interface{}, error) {
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	earliest, latest, err := c.client.GetCoverage(ctx)
+	done()
+
+	c.coverage.mu.Lock()
+	defer c.coverage.mu.Unlock()
+	c.coverage.attempted = c.clock.Now()
+	if err != nil {
+		c.coverage.failures++
+		log.Debug("failed to get archiver coverage", "failures", c.coverage.failures, "err", err)
+		return nil, err
+	}
+	c.coverage.earliest, c.coverage.latest, c.coverage.updated = earliest, latest, c.coverage.attempted
+	c.coverage.known, c.coverage.failures = true, 0
+	return nil, nil
+}
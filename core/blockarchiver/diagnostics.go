@@ -0,0 +1,44 @@
+package blockarchiver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// RequestIDHeader is the response header carrying the gateway request id, which is added to the
+// errors of the failed requests when the diagnostic headers are enabled
+const RequestIDHeader = "X-Request-Id"
+
+// WithDiagnosticHeaders logs the given response headers of every block archiver request at debug
+// level, e.g. the cache status or the upstream server set by a gateway, to correlate the requests
+// with the archiver side logs. The gateway request id is added to the errors of failed requests
+// when present. Nothing is captured without this option.
+func WithDiagnosticHeaders(headers ...string) Option {
+	return func(c *Client) {
+		c.diagnosticHeaders = make([]string, 0, len(headers))
+		for _, header := range headers {
+			c.diagnosticHeaders = append(c.diagnosticHeaders, http.CanonicalHeaderKey(header))
+		}
+	}
+}
+
+// diagnose logs the allowlisted headers of a response and annotates err with the gateway request
+// id if any. It is a no-op unless the diagnostic headers are enabled.
+func (c *Client) diagnose(resp *http.Response, err error) error {
+	if c.diagnosticHeaders == nil {
+		return err
+	}
+	ctx := []interface{}{"url", resp.Request.URL, "status", resp.StatusCode}
+	for _, header := range c.diagnosticHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			ctx = append(ctx, header, value)
+		}
+	}
+	log.Debug("block archiver response", ctx...)
+	if id := resp.Header.Get(RequestIDHeader); err != nil && id != "" {
+		return fmt.Errorf("%w (request id %s)", err, id)
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package blockarchiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// DiskCacheFormat is the serialization of the bundles kept in the disk cache
+type DiskCacheFormat string
+
+const (
+	// DiskCacheRLP is the default, it RLP encodes the blocks as served by the archiver, hex strings
+	// included, so it only saves the field names and the quoting of the JSON format
+	DiskCacheRLP DiskCacheFormat = "rlp"
+	// DiskCacheJSON keeps the bundles as served by the archiver, which is easier to inspect
+	DiskCacheJSON DiskCacheFormat = "json"
+)
+
+// diskCacheMagic and diskCacheVersion start every file of the disk cache, followed by a byte
+// telling the format of the payload
+var diskCacheMagic = []byte("bsca")
+
+const diskCacheVersion = 1
+
+// diskCacheFormats maps the formats to the byte stored in the file header
+var diskCacheFormats = map[DiskCacheFormat]byte{DiskCacheRLP: 1, DiskCacheJSON: 2}
+
+// diskCache keeps the fetched bundles on disk, one file per bundle, so they survive restarts
+type diskCache struct {
+	dir    string
+	format DiskCacheFormat
+}
+
+// newDiskCache creates a disk cache in dir writing the given format, RLP if empty
+func newDiskCache(dir string, format DiskCacheFormat) (*diskCache, error) {
+	if format == "" {
+		format = DiskCacheRLP
+	}
+	if _, ok := diskCacheFormats[format]; !ok {
+		return nil, fmt.Errorf("unknown disk cache format %q", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir, format: format}, nil
+}
+
+// path returns the file of a bundle
+func (d *diskCache) path(bundleName string) string {
+	return filepath.Join(d.dir, filepath.Base(bundleName))
+}
+
+// load reads the blocks of a bundle. Files written in any supported format are read, whatever
+// the configured one, while files of another version are rejected.
+func (d *diskCache) load(bundleName string) ([]*Block, error) {
+	data, err := os.ReadFile(d.path(bundleName))
+	if err != nil {
+		return nil, err
+	}
+	header := len(diskCacheMagic) + 2
+	if len(data) < header || !bytes.Equal(data[:len(diskCacheMagic)], diskCacheMagic) {
+		return nil, errors.New("not a disk cache file")
+	}
+	if version := data[len(diskCacheMagic)]; version != diskCacheVersion {
+		return nil, fmt.Errorf("unsupported disk cache version %d", version)
+	}
+	var blocks []*Block
+	switch payload := data[header:]; data[header-1] {
+	case diskCacheFormats[DiskCacheRLP]:
+		err = rlp.DecodeBytes(payload, &blocks)
+	case diskCacheFormats[DiskCacheJSON]:
+		err = unmarshalJSON(payload, &blocks)
+	default:
+		err = fmt.Errorf("unsupported disk cache format %d", data[header-1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// store writes the blocks of a bundle, the file is replaced atomically so a crash never leaves
+// a partial file behind
+func (d *diskCache) store(bundleName string, blocks []*Block) error {
+	var payload []byte
+	var err error
+	switch d.format {
+	case DiskCacheRLP:
+		payload, err = rlp.EncodeToBytes(blocks)
+	case DiskCacheJSON:
+		payload, err = json.Marshal(blocks)
+	}
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 0, len(diskCacheMagic)+2+len(payload))
+	data = append(data, diskCacheMagic...)
+	data = append(data, diskCacheVersion, diskCacheFormats[d.format])
+	data = append(data, payload...)
+
+	tmp, err := os.CreateTemp(d.dir, filepath.Base(bundleName)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(bundleName))
+}
+
+// remove deletes the file of a bundle, e.g. once found corrupted
+func (d *diskCache) remove(bundleName string) {
+	os.Remove(d.path(bundleName))
+}
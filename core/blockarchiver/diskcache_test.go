@@ -0,0 +1,130 @@
+package blockarchiver
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestDiskCacheRoundTrip(t *testing.T) {
+	blocks := newTestChain(t, 100, 3)
+	blocks[2].Withdrawals = []Withdrawal{{Index: "0x1", ValidatorIndex: "0x2", Address: testMiner.Hex(), Amount: "0x3"}}
+
+	for _, format := range []DiskCacheFormat{DiskCacheRLP, DiskCacheJSON} {
+		cache, err := newDiskCache(t.TempDir(), format)
+		if err != nil {
+			t.Fatalf("%s: failed to create disk cache: %v", format, err)
+		}
+		if err := cache.store("blocks_s100_e102", blocks); err != nil {
+			t.Fatalf("%s: failed to store bundle: %v", format, err)
+		}
+		loaded, err := cache.load("blocks_s100_e102")
+		if err != nil {
+			t.Fatalf("%s: failed to load bundle: %v", format, err)
+		}
+		if len(loaded) != len(blocks) {
+			t.Fatalf("%s: want %d blocks, got %d", format, len(blocks), len(loaded))
+		}
+		for i := range blocks {
+			want, err := convertBlock(blocks[i])
+			if err != nil {
+				t.Fatalf("%s: failed to convert block: %v", format, err)
+			}
+			got, err := convertBlock(loaded[i])
+			if err != nil {
+				t.Fatalf("%s: failed to convert loaded block: %v", format, err)
+			}
+			if got.Hash() != want.Hash() || got.Transactions().Len() != want.Transactions().Len() {
+				t.Errorf("%s: block %d mismatch", format, i)
+			}
+			for j, tx := range got.Transactions() {
+				if tx.Hash() != want.Transactions()[j].Hash() {
+					t.Errorf("%s: block %d transaction %d mismatch", format, i, j)
+				}
+			}
+		}
+		if len(loaded[2].Withdrawals) != 1 || loaded[2].Withdrawals[0] != blocks[2].Withdrawals[0] {
+			t.Errorf("%s: withdrawals mismatch, want %v, got %v", format, blocks[2].Withdrawals, loaded[2].Withdrawals)
+		}
+	}
+	if _, err := newDiskCache(t.TempDir(), "xml"); err == nil {
+		t.Error("want error for an unknown format, got nil")
+	}
+}
+
+func TestDiskCacheSizes(t *testing.T) {
+	blocks := newTestChain(t, 100, 10)
+	sizes := make(map[DiskCacheFormat]int64)
+	for _, format := range []DiskCacheFormat{DiskCacheRLP, DiskCacheJSON} {
+		cache, err := newDiskCache(t.TempDir(), format)
+		if err != nil {
+			t.Fatalf("%s: failed to create disk cache: %v", format, err)
+		}
+		if err := cache.store("blocks_s100_e109", blocks); err != nil {
+			t.Fatalf("%s: failed to store bundle: %v", format, err)
+		}
+		info, err := os.Stat(cache.path("blocks_s100_e109"))
+		if err != nil {
+			t.Fatalf("%s: failed to stat bundle: %v", format, err)
+		}
+		sizes[format] = info.Size()
+	}
+	// the RLP files keep the hex strings of the wire blocks, they only drop the field names
+	if sizes[DiskCacheRLP] >= sizes[DiskCacheJSON] || sizes[DiskCacheRLP] < sizes[DiskCacheJSON]/2 {
+		t.Errorf("want the RLP file between half and the size of the JSON one, rlp %d, json %d bytes",
+			sizes[DiskCacheRLP], sizes[DiskCacheJSON])
+	}
+}
+
+func TestDiskCacheCorruption(t *testing.T) {
+	blocks := newTestChain(t, 100, 10)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+	cache, err := newDiskCache(t.TempDir(), DiskCacheRLP)
+	if err != nil {
+		t.Fatalf("failed to create disk cache: %v", err)
+	}
+	service.diskCache = cache
+
+	// a bundle on disk is served without fetching it
+	if err := cache.store("blocks_s100_e109", blocks); err != nil {
+		t.Fatalf("failed to store bundle: %v", err)
+	}
+	_, header, err := service.GetBlockByNumber(101)
+	if err != nil {
+		t.Fatalf("failed to get block from the disk cache: %v", err)
+	}
+	if header.Hash().Hex() != blocks[1].Hash {
+		t.Errorf("block 101 hash mismatch, want %s, got %s", blocks[1].Hash, header.Hash().Hex())
+	}
+
+	// a truncated file is rejected, dropped and the bundle fetched again
+	path := cache.path("blocks_s100_e109")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read bundle file: %v", err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("failed to truncate bundle file: %v", err)
+	}
+	if _, err := cache.load("blocks_s100_e109"); err == nil {
+		t.Fatal("want error for a truncated file, got nil")
+	}
+	service.hashCache.Purge()
+	// the mock storage provider can't serve the bundle, so the fallback fetch fails
+	if _, _, err := service.GetBlockByNumber(102); err == nil {
+		t.Error("want error from the fallback fetch, got nil")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want corrupted file dropped, got %v", err)
+	}
+
+	// a file of another version is rejected
+	data[len(diskCacheMagic)] = diskCacheVersion + 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write bundle file: %v", err)
+	}
+	if _, err := cache.load("blocks_s100_e109"); err == nil {
+		t.Error("want error for an unsupported version, got nil")
+	}
+}
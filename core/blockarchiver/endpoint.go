@@ -0,0 +1,131 @@
+package blockarchiver
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// EndpointPolicy configures how the client talks to one of the block archiver endpoints
+type EndpointPolicy struct {
+	// Timeout bounds every request sent to the endpoint on top of the caller's deadline, zero
+	// only applies the caller's deadline
+	Timeout time.Duration
+	// Backoff decides the delay between retries of a failed request, nil keeps the client default
+	Backoff BackoffStrategy
+}
+
+// endpoint is one of the gateways of the block archiver: the JSON-RPC gateway serving blocks or
+// the REST gateway serving bundle names. They are deployed separately and may fail or slow down
+// independently, so each has its own policy and metrics.
+type endpoint struct {
+	name    string
+	timeout time.Duration
+	backoff BackoffStrategy
+
+	requests metrics.Timer
+	errors   metrics.Counter
+
+	// failures counts the consecutive failed requests, lastSuccess and lastFailure are unix nanos
+	failures    atomic.Int64
+	lastSuccess atomic.Int64
+	lastFailure atomic.Int64
+}
+
+// newEndpoint creates an endpoint with the default policy, its metrics are registered under
+// blockarchiver/endpoint/<name>/
+func newEndpoint(name string) *endpoint {
+	prefix := "blockarchiver/endpoint/" + name + "/"
+	return &endpoint{
+		name:     name,
+		backoff:  DefaultBackoff(),
+		requests: metrics.GetOrRegisterTimer(prefix+"requests", nil),
+		errors:   metrics.GetOrRegisterCounter(prefix+"errors", nil),
+	}
+}
+
+// apply overrides the policy of the endpoint with the set fields of the given policy
+func (e *endpoint) apply(policy EndpointPolicy) {
+	if policy.Timeout > 0 {
+		e.timeout = policy.Timeout
+	}
+	if policy.Backoff != nil {
+		e.backoff = policy.Backoff
+	}
+}
+
+// context returns the context a request to the endpoint is sent with
+func (e *endpoint) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Value(methodTimeoutKey{}).(bool); e.timeout > 0 && !ok {
+		return context.WithTimeout(ctx, e.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// record accounts a finished request to the endpoint. Like for the hosts of a ring, only the
+// errors a retry may fix count as failures, an endpoint answering e.g. that a block is unknown
+// is healthy.
+func (e *endpoint) record(start time.Time, err error) {
+	e.requests.UpdateSince(start)
+	now := time.Now().UnixNano()
+	if err != nil {
+		e.errors.Inc(1)
+	}
+	if err != nil && retryable(err) {
+		e.failures.Add(1)
+		e.lastFailure.Store(now)
+		return
+	}
+	e.failures.Store(0)
+	e.lastSuccess.Store(now)
+}
+
+// WithRPCPolicy sets the policy of the JSON-RPC endpoint serving the blocks
+func WithRPCPolicy(policy EndpointPolicy) Option {
+	return func(c *Client) {
+		c.rpc.apply(policy)
+	}
+}
+
+// WithRESTPolicy sets the policy of the REST endpoint serving the bundle names
+func WithRESTPolicy(policy EndpointPolicy) Option {
+	return func(c *Client) {
+		c.rest.apply(policy)
+	}
+}
+
+// methodTimeoutKey marks the contexts bounded by a per-method timeout, which replaces the timeout
+// of the endpoints for the requests sent with them
+type methodTimeoutKey struct{}
+
+// WithMethodTimeouts sets the timeouts of specific client methods by name, e.g.
+// "GetBundleBlocksByBlockNum" or "GetLatestBlock". A method timeout bounds the whole call,
+// including pagination and host failover. The timeouts are applied in this order of precedence:
+//
+//   - the method timeout, if set for the called method
+//   - otherwise the timeout of the endpoint the request goes to, see EndpointPolicy
+//   - otherwise no timeout but the caller's deadline
+//
+// The caller's deadline always applies, whichever of the timeouts is picked.
+func WithMethodTimeouts(timeouts map[string]time.Duration) Option {
+	return func(c *Client) {
+		c.methodTimeouts = make(map[string]time.Duration, len(timeouts))
+		for method, timeout := range timeouts {
+			if timeout > 0 {
+				c.methodTimeouts[method] = timeout
+			}
+		}
+	}
+}
+
+// methodContext returns the context a client method runs with, bounded by the timeout of the
+// method if one is configured
+func (c *Client) methodContext(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	timeout, ok := c.methodTimeouts[method]
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(context.WithValue(ctx, methodTimeoutKey{}, true), timeout)
+}
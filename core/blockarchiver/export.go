@@ -0,0 +1,41 @@
+package blockarchiver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ExportCachedToRLP writes the blocks in [from, to] to w as a stream of RLP encoded blocks, the
+// format read by geth import, e.g. to seed the ancient store of a fresh node. The blocks are read
+// from the caches and the missing ones are fetched, one at a time so the memory stays bounded.
+// Every block is checked to hash to its header before it is written.
+func (c *BlockArchiverService) ExportCachedToRLP(from, to uint64, w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	err := c.IterateCached(from, to, func(header *types.Header, body *types.Body) error {
+		block, err := assembleBlock(header, body)
+		if err != nil {
+			return err
+		}
+		if err := verifyBlockBody(block); err != nil {
+			return err
+		}
+		return rlp.Encode(buf, block)
+	})
+	if err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// verifyBlockBody checks the transactions, uncles and withdrawals of a block against the roots
+// of its header, i.e. that the whole block hashes to its header hash
+func verifyBlockBody(block *types.Block) error {
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return fmt.Errorf("block %d uncle hash mismatch, header %s, computed %s", block.NumberU64(), block.UncleHash(), hash)
+	}
+	return verifyBodyRoots(block)
+}
@@ -0,0 +1,55 @@
+package blockarchiver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestExportCachedToRLP(t *testing.T) {
+	chain := newTestChain(t, 100, 3)
+	service := newTestService(t, newTestArchiver(chain...))
+	for _, wire := range chain {
+		block, err := convertBlock(wire)
+		if err != nil {
+			t.Fatalf("failed to convert block: %v", err)
+		}
+		service.cacheBlock(block.Block)
+	}
+
+	var out bytes.Buffer
+	if err := service.ExportCachedToRLP(100, 102, &out); err != nil {
+		t.Fatalf("failed to export blocks: %v", err)
+	}
+	stream := rlp.NewStream(&out, 0)
+	for i := 0; ; i++ {
+		var block types.Block
+		err := stream.Decode(&block)
+		if errors.Is(err, io.EOF) {
+			if i != len(chain) {
+				t.Errorf("want %d exported blocks, got %d", len(chain), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to decode block %d: %v", i, err)
+		}
+		if block.Hash().Hex() != chain[i].Hash || len(block.Transactions()) != len(chain[i].Transactions) {
+			t.Errorf("exported block %d mismatch", i)
+		}
+	}
+
+	// a body not matching its header is never written
+	_, header, err := service.GetBlockByNumber(101)
+	if err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	service.bodyCache.Add(header.Hash(), &types.Body{})
+	if err := service.ExportCachedToRLP(100, 102, io.Discard); err == nil {
+		t.Error("want an error exporting a block with a tampered body")
+	}
+}
@@ -0,0 +1,290 @@
+package blockarchiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// The fixtures are canned block archiver responses replayed by fixtureTransport, so conversion
+// can be tested against real blocks without a live archiver. They are stored gzipped in
+// testdata/fixtures, one file per set of blocks, keyed by request rather than by order.
+//
+// To refresh them, run the fixture tests against a live archiver, which records every response
+// and rewrites the files:
+//
+//	go test ./core/blockarchiver -run Fixture -record-fixtures=https://<block archiver host>
+//
+// The mock fixtures are recorded from the mock archiver instead, with -record-mock-fixtures.
+var (
+	recordFixtures     = flag.String("record-fixtures", "", "Record the fixtures from the given block archiver into testdata/fixtures")
+	recordMockFixtures = flag.Bool("record-mock-fixtures", false, "Record the fixtures of the mock archiver into testdata/fixtures")
+)
+
+// goldenFixtures are the fixture files checked by TestFixtureGolden and their blocks. The mock
+// file holds blocks of the mock archiver, so the replay runs even before mainnet is recorded.
+var goldenFixtures = []struct {
+	file   string
+	blocks []uint64
+	mock   bool
+}{
+	{file: "golden.json.gz", blocks: []uint64{1, 1_000_000, 10_000_000, 30_000_000, 40_000_000}},
+	{file: "mock.json.gz", blocks: []uint64{100, 105, 109}, mock: true},
+}
+
+// fixtureResponse is a recorded response
+type fixtureResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// fixtureTransport replays the responses of a fixture file, or records them when upstream is set
+type fixtureTransport struct {
+	path     string
+	upstream http.RoundTripper
+
+	mu        sync.Mutex
+	responses map[string]fixtureResponse
+}
+
+// newFixtureTransport loads the fixture file at path, or starts an empty recording forwarding
+// the requests to upstream if it is non-nil
+func newFixtureTransport(path string, upstream http.RoundTripper) (*fixtureTransport, error) {
+	t := &fixtureTransport{path: path, upstream: upstream, responses: make(map[string]fixtureResponse)}
+	if upstream != nil {
+		return t, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewDecoder(zr).Decode(&t.responses); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// fixtureKey identifies a request regardless of its JSON-RPC ids and formatting
+func fixtureKey(req *http.Request, body []byte) (string, error) {
+	if req.Method != http.MethodPost {
+		return req.Method + " " + req.URL.Path, nil
+	}
+	type call struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	var calls []call
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		if err := json.Unmarshal(body, &calls); err != nil {
+			return "", err
+		}
+	} else {
+		var single call
+		if err := json.Unmarshal(body, &single); err != nil {
+			return "", err
+		}
+		calls = append(calls, single)
+	}
+	key := new(bytes.Buffer)
+	for _, c := range calls {
+		key.WriteString(c.Method)
+		if err := json.Compact(key, c.Params); err != nil {
+			return "", err
+		}
+	}
+	return key.String(), nil
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	key, err := fixtureKey(req, body)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.upstream != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		resp, err := t.upstream.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		recorded, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		t.responses[key] = fixtureResponse{Status: resp.StatusCode, Body: string(recorded)}
+	}
+	recorded, ok := t.responses[key]
+	if !ok {
+		return nil, fmt.Errorf("no fixture for request %s", key)
+	}
+	return &http.Response{
+		StatusCode: recorded.Status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewBufferString(recorded.Body)),
+		Request:    req,
+	}, nil
+}
+
+// save writes the recorded responses to the fixture file
+func (t *fixtureTransport) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	zw, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err := json.NewEncoder(zw).Encode(t.responses); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, buf.Bytes(), 0644)
+}
+
+// newFixtureClient returns a client replaying the fixture file of the test, or recording it from
+// the given archiver host if non-empty. Recordings are saved when the test ends.
+func newFixtureClient(t *testing.T, path, record string) *Client {
+	t.Helper()
+	var upstream http.RoundTripper
+	host := "http://fixture.invalid"
+	if record != "" {
+		upstream, host = http.DefaultTransport, record
+	}
+	transport, err := newFixtureTransport(path, upstream)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skipf("no recorded fixtures at %s, see -record-fixtures", path)
+	}
+	if err != nil {
+		t.Fatalf("failed to load fixtures: %v", err)
+	}
+	client, err := New(host, host, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.hc.Transport = transport
+	if upstream != nil {
+		t.Cleanup(func() {
+			if err := transport.save(); err != nil {
+				t.Errorf("failed to save fixtures: %v", err)
+			}
+		})
+	}
+	return client
+}
+
+func TestFixtureRecordReplay(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "fixtures.json.gz")
+
+	// record against the mock archiver
+	t.Run("record", func(t *testing.T) {
+		client := newFixtureClient(t, path, server.URL)
+		if _, err := client.GetBlockByNumber(context.Background(), 105); err != nil {
+			t.Fatalf("failed to record block: %v", err)
+		}
+		if _, err := client.GetHeadersByRange(context.Background(), 100, 109); err != nil {
+			t.Fatalf("failed to record headers: %v", err)
+		}
+		if _, err := client.GetBundleName(context.Background(), 105); err != nil {
+			t.Fatalf("failed to record bundle name: %v", err)
+		}
+	})
+	served := archiver.callCount("eth_getBlockByNumber")
+
+	// replay without the archiver
+	client := newFixtureClient(t, path, "")
+	block, err := client.GetBlockByNumber(context.Background(), 105)
+	if err != nil {
+		t.Fatalf("failed to replay block: %v", err)
+	}
+	if block.Hash != archiver.blocks[105].Hash {
+		t.Errorf("replayed block hash mismatch, want %s, got %s", archiver.blocks[105].Hash, block.Hash)
+	}
+	if headers, err := client.GetHeadersByRange(context.Background(), 100, 109); err != nil || len(headers) != 10 {
+		t.Errorf("failed to replay headers: %d headers, %v", len(headers), err)
+	}
+	if name, err := client.GetBundleName(context.Background(), 105); err != nil || name != "blocks_s100_e109" {
+		t.Errorf("failed to replay bundle name: %q, %v", name, err)
+	}
+	if _, err := client.GetBlockByNumber(context.Background(), 106); err == nil {
+		t.Error("want error for a request without fixture, got nil")
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != served {
+		t.Errorf("want no archiver request on replay, got %d", n-served)
+	}
+}
+
+func TestFixtureGolden(t *testing.T) {
+	for _, fixture := range goldenFixtures {
+		t.Run(fixture.file, func(t *testing.T) {
+			record := *recordFixtures
+			if fixture.mock {
+				record = ""
+				if *recordMockFixtures {
+					server := httptest.NewServer(newTestArchiver(newTestChain(t, 100, 10)...))
+					defer server.Close()
+					record = server.URL
+				}
+			}
+			client := newFixtureClient(t, filepath.Join("testdata", "fixtures", fixture.file), record)
+			checkFixtureBlocks(t, client, fixture.blocks)
+		})
+	}
+}
+
+// checkFixtureBlocks fetches the given blocks and checks they convert to blocks hashing as served
+func checkFixtureBlocks(t *testing.T, client *Client, numbers []uint64) {
+	t.Helper()
+	for _, number := range numbers {
+		block, err := client.GetBlockByNumber(context.Background(), number)
+		if err != nil {
+			t.Fatalf("block %d: failed to get block: %v", number, err)
+		}
+		if block == nil {
+			t.Fatalf("block %d: not found", number)
+		}
+		// the header must hash to the archiver's hash and every transaction to its reported hash
+		if _, err := convertVerifiedHeader(block); err != nil {
+			t.Errorf("block %d: %v", number, err)
+		}
+		converted, err := convertBlock(block)
+		if err != nil {
+			t.Errorf("block %d: failed to convert block: %v", number, err)
+			continue
+		}
+		if err := verifyTransactionsRoot(converted.Block); err != nil {
+			t.Errorf("block %d: %v", number, err)
+		}
+	}
+}
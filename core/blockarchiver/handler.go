@@ -0,0 +1,96 @@
+package blockarchiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// unhealthyFailures is the number of consecutive failed requests after which an endpoint of
+// the block archiver is reported unreachable
+const unhealthyFailures = 3
+
+// Stats is a snapshot of the state of the block archiver service
+type Stats struct {
+	BodyCache        int                      `json:"bodyCache"`
+	HeaderCache      int                      `json:"headerCache"`
+	HashCache        int                      `json:"hashCache"`
+	InflightRequests int64                    `json:"inflightRequests"`
+	FetchSlotsInUse  int                      `json:"fetchSlotsInUse"`
+	FetchQueued      int                      `json:"fetchQueued"`
+	Endpoints        map[string]EndpointStats `json:"endpoints"`
+}
+
+// EndpointStats is a snapshot of the state of one of the block archiver endpoints
+type EndpointStats struct {
+	ConsecutiveFailures int64      `json:"consecutiveFailures"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+}
+
+// Stats returns a snapshot of the caches, the in-flight work and the endpoints of the service
+func (c *BlockArchiverService) Stats() Stats {
+	stats := Stats{
+		BodyCache:        c.bodyCache.Len(),
+		HeaderCache:      c.headerCache.Len(),
+		HashCache:        c.hashCache.Len(),
+		InflightRequests: c.inflight.Load(),
+		FetchSlotsInUse:  c.fetchSlots.inUse(),
+		FetchQueued:      c.fetchSlots.queued(priorityForeground) + c.fetchSlots.queued(priorityBackground),
+		Endpoints:        make(map[string]EndpointStats),
+	}
+	for _, e := range []*endpoint{c.client.rpc, c.client.rest} {
+		stats.Endpoints[e.name] = e.stats()
+	}
+	return stats
+}
+
+// stats returns a snapshot of the state of the endpoint
+func (e *endpoint) stats() EndpointStats {
+	stats := EndpointStats{ConsecutiveFailures: e.failures.Load()}
+	if t := e.lastSuccess.Load(); t != 0 {
+		last := time.Unix(0, t)
+		stats.LastSuccess = &last
+	}
+	if t := e.lastFailure.Load(); t != 0 {
+		last := time.Unix(0, t)
+		stats.LastFailure = &last
+	}
+	return stats
+}
+
+// Health returns an error if an endpoint of the block archiver failed its last requests
+func (c *BlockArchiverService) Health() error {
+	for _, e := range []*endpoint{c.client.rpc, c.client.rest} {
+		if failures := e.failures.Load(); failures >= unhealthyFailures {
+			return fmt.Errorf("%w: %s endpoint unreachable, %d consecutive failures", ErrArchiverUnavailable, e.name, failures)
+		}
+	}
+	return nil
+}
+
+// Handler returns a read-only http.Handler serving the health of the service at /health and the
+// Stats snapshot at /stats, both as JSON. It is meant to be mounted into an existing admin
+// server, e.g. with http.StripPrefix("/archiver", service.Handler()).
+func (c *BlockArchiverService) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		status, resp := http.StatusOK, map[string]interface{}{"healthy": true}
+		if err := c.Health(); err != nil {
+			status, resp = http.StatusServiceUnavailable, map[string]interface{}{"healthy": false, "error": err.Error()}
+		}
+		writeJSON(w, status, resp)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Stats())
+	})
+	return mux
+}
+
+// writeJSON writes v as the JSON body of a response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,53 @@
+package blockarchiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	blocks := newTestChain(t, 100, 1)
+	service := newTestService(t, newTestArchiver(blocks...))
+	block, err := convertBlock(blocks[0])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(block.Block)
+	if _, err := service.GetLatestBlock(); err != nil {
+		t.Fatalf("failed to get latest block: %v", err)
+	}
+	handler := http.StripPrefix("/archiver", service.Handler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/archiver/stats", nil))
+	var stats Stats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if stats.BodyCache != 1 || stats.HeaderCache != 1 || stats.HashCache != 1 {
+		t.Errorf("cache stats mismatch: %+v", stats)
+	}
+	if rpc := stats.Endpoints["rpc"]; rpc.LastSuccess == nil || rpc.ConsecutiveFailures != 0 {
+		t.Errorf("want a successful rpc request recorded, got %+v", rpc)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/archiver/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("want healthy service, got status %d: %s", rec.Code, rec.Body)
+	}
+
+	// the archiver goes away, the latest block is no longer reused so every call reaches it
+	service.client.blockArchiverHost = "http://127.0.0.1:1"
+	service.latestTTL = 0
+	for i := 0; i < unhealthyFailures; i++ {
+		service.GetLatestBlock()
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/archiver/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want unhealthy service, got status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,61 @@
+package blockarchiver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// IterateCached calls fn for every block in [from, to] in ascending order. Blocks are read from
+// the caches and the missing ones are fetched, a fetch fills the cache with the whole bundle so
+// the following blocks are usually served from the cache again. Use IterateCacheOnly to skip the
+// missing blocks instead. The iteration stops at the first error, either from fn or a fetch.
+func (c *BlockArchiverService) IterateCached(from, to uint64, fn func(*types.Header, *types.Body) error) error {
+	return c.iterate(from, to, true, fn)
+}
+
+// IterateCacheOnly calls fn for every cached block in [from, to] in ascending order, the blocks
+// missing from the caches are skipped and never fetched
+func (c *BlockArchiverService) IterateCacheOnly(from, to uint64, fn func(*types.Header, *types.Body) error) error {
+	return c.iterate(from, to, false, fn)
+}
+
+// iterate walks the blocks in [from, to], fetching the ones missing from the caches if fetch is set
+func (c *BlockArchiverService) iterate(from, to uint64, fetch bool, fn func(*types.Header, *types.Body) error) error {
+	if from > to {
+		return fmt.Errorf("invalid block range [%d, %d]", from, to)
+	}
+	for number := from; ; number++ {
+		header, body, found := c.cachedBlock(number)
+		if !found && fetch {
+			var err error
+			if body, header, err = c.getBlockByNumber(context.Background(), number); err != nil {
+				return err
+			}
+			found = header != nil && body != nil
+			if !found {
+				return fmt.Errorf("%w: number %d", ErrBlockNotFound, number)
+			}
+		}
+		if found {
+			if err := fn(header, body); err != nil {
+				return err
+			}
+		}
+		if number == to {
+			return nil
+		}
+	}
+}
+
+// cachedBlock returns the block by number if both its header and body are cached
+func (c *BlockArchiverService) cachedBlock(number uint64) (*types.Header, *types.Body, bool) {
+	hash, found := c.hashCache.Get(number)
+	if !found {
+		return nil, nil, false
+	}
+	body, foundB := c.bodyCache.Get(hash)
+	header, foundH := c.headerCache.Get(hash)
+	return header, body, foundB && foundH
+}
@@ -0,0 +1,68 @@
+package blockarchiver
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestIterateCached(t *testing.T) {
+	blocks := newTestChain(t, 100, 5)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+	// leave a gap at 102
+	for i, wire := range blocks {
+		if i == 2 {
+			continue
+		}
+		block, err := convertBlock(wire)
+		if err != nil {
+			t.Fatalf("failed to convert block: %v", err)
+		}
+		service.cacheBlock(block.Block)
+	}
+
+	var visited []uint64
+	collect := func(header *types.Header, body *types.Body) error {
+		if len(body.Transactions) != len(blocks[0].Transactions) {
+			t.Errorf("block %v transaction count mismatch", header.Number)
+		}
+		visited = append(visited, header.Number.Uint64())
+		return nil
+	}
+	if err := service.IterateCacheOnly(100, 104, collect); err != nil {
+		t.Fatalf("failed to iterate cached blocks: %v", err)
+	}
+	if want := []uint64{100, 101, 103, 104}; !slices.Equal(visited, want) {
+		t.Errorf("cache only iteration mismatch, want %v, got %v", want, visited)
+	}
+	if n := archiver.callCount("bundle/name"); n != 0 {
+		t.Errorf("want no fetch in a cache only iteration, got %d bundle requests", n)
+	}
+
+	// the gap is fetched, the bundle fetch can't be served by the mock storage provider so the
+	// iteration stops with its error after the cached blocks before the gap
+	visited = nil
+	if err := service.IterateCached(100, 104, collect); err == nil {
+		t.Fatal("want error from the failed gap fetch, got nil")
+	}
+	if want := []uint64{100, 101}; !slices.Equal(visited, want) {
+		t.Errorf("iteration mismatch, want %v, got %v", want, visited)
+	}
+	if n := archiver.callCount("bundle/name"); n != 1 {
+		t.Errorf("want the gap fetched, got %d bundle requests", n)
+	}
+
+	// the callback error stops the iteration
+	stop := errors.New("stop")
+	visited = nil
+	err := service.IterateCacheOnly(100, 104, func(header *types.Header, body *types.Body) error {
+		visited = append(visited, header.Number.Uint64())
+		return stop
+	})
+	if err != stop || len(visited) != 1 {
+		t.Errorf("want iteration stopped after the first block, got %v after %v", err, visited)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !goccy
+
+package blockarchiver
+
+import "encoding/json"
+
+// unmarshalJSON decodes the block archiver responses and the bundled blocks. It is encoding/json
+// by default, building with the goccy tag swaps in github.com/goccy/go-json, see json_goccy.go.
+var unmarshalJSON = json.Unmarshal
@@ -0,0 +1,13 @@
+//go:build goccy
+
+package blockarchiver
+
+import gojson "github.com/goccy/go-json"
+
+// unmarshalJSON decodes the block archiver responses and the bundled blocks with goccy/go-json,
+// a drop-in replacement of encoding/json which is considerably faster on large bundles. Compare
+// both with:
+//
+//	go test -run - -bench DecodeBundle ./core/blockarchiver
+//	go test -tags goccy -run - -bench DecodeBundle ./core/blockarchiver
+var unmarshalJSON = gojson.Unmarshal
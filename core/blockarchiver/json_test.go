@@ -0,0 +1,54 @@
+package blockarchiver
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// encodeTestBundle returns the JSON encoding of a bundle of count blocks
+func encodeTestBundle(t testing.TB, count uint64) []byte {
+	t.Helper()
+	blocks := newTestChain(t, 1000, count)
+	blocks[0].Withdrawals = []Withdrawal{{Index: "0x1", ValidatorIndex: "0x2", Address: testMiner.Hex(), Amount: "0x3"}}
+	enc, err := json.Marshal(GetBlocksResponse{ID: 1, Jsonrpc: "2.0", Result: blocks, NextPageToken: "10"})
+	if err != nil {
+		t.Fatalf("failed to encode bundle: %v", err)
+	}
+	return enc
+}
+
+// TestUnmarshalJSON checks the configured decoder against encoding/json, which makes it a
+// differential test when built with the goccy tag.
+func TestUnmarshalJSON(t *testing.T) {
+	enc := encodeTestBundle(t, 10)
+
+	var want, got GetBlocksResponse
+	if err := json.Unmarshal(enc, &want); err != nil {
+		t.Fatalf("encoding/json failed to decode bundle: %v", err)
+	}
+	if err := unmarshalJSON(enc, &got); err != nil {
+		t.Fatalf("failed to decode bundle: %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("decoded bundle mismatch\nwant %+v\ngot  %+v", want, got)
+	}
+	for _, malformed := range []string{`{"result": [{"number": 1}]}`, `{"result"`} {
+		var w, g GetBlocksResponse
+		if (json.Unmarshal([]byte(malformed), &w) == nil) != (unmarshalJSON([]byte(malformed), &g) == nil) {
+			t.Errorf("decoders disagree on %q", malformed)
+		}
+	}
+}
+
+func BenchmarkDecodeBundle(b *testing.B) {
+	enc := encodeTestBundle(b, 100)
+	b.SetBytes(int64(len(enc)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var resp GetBlocksResponse
+		if err := unmarshalJSON(enc, &resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package blockarchiver
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RPCMethods names the JSON-RPC methods called on the block archiver, so the client can follow
+// an archiver renaming them. Empty fields keep the default names.
+type RPCMethods struct {
+	ChainID                 string
+	GetBlockByHash          string
+	GetBlockByNumber        string
+	GetBlockReceipts        string
+	GetBundledBlockByNumber string
+	GetLogs                 string
+	GetTransactionByHash    string
+	GetTransactionByIndex   string
+	GetTransactionReceipt   string
+	TraceBlockByNumber      string
+}
+
+// DefaultRPCMethods are the method names served by the block archiver
+var DefaultRPCMethods = RPCMethods{
+	ChainID:                 "eth_chainId",
+	GetBlockByHash:          "eth_getBlockByHash",
+	GetBlockByNumber:        "eth_getBlockByNumber",
+	GetBlockReceipts:        "eth_getBlockReceipts",
+	GetBundledBlockByNumber: "eth_getBundledBlockByNumber",
+	GetLogs:                 "eth_getLogs",
+	GetTransactionByHash:    "eth_getTransactionByHash",
+	GetTransactionByIndex:   "eth_getTransactionByBlockNumberAndIndex",
+	GetTransactionReceipt:   "eth_getTransactionReceipt",
+	TraceBlockByNumber:      "debug_traceBlockByNumber",
+}
+
+// rpcMethodName matches the JSON-RPC method names, i.e. namespace_method
+var rpcMethodName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*_[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// merge returns the methods with the empty fields set from defaults
+func (m RPCMethods) merge(defaults RPCMethods) RPCMethods {
+	for _, field := range []struct{ name, fallback *string }{
+		{&m.ChainID, &defaults.ChainID},
+		{&m.GetBlockByHash, &defaults.GetBlockByHash},
+		{&m.GetBlockByNumber, &defaults.GetBlockByNumber},
+		{&m.GetBlockReceipts, &defaults.GetBlockReceipts},
+		{&m.GetBundledBlockByNumber, &defaults.GetBundledBlockByNumber},
+		{&m.GetLogs, &defaults.GetLogs},
+		{&m.GetTransactionByHash, &defaults.GetTransactionByHash},
+		{&m.GetTransactionByIndex, &defaults.GetTransactionByIndex},
+		{&m.GetTransactionReceipt, &defaults.GetTransactionReceipt},
+		{&m.TraceBlockByNumber, &defaults.TraceBlockByNumber},
+	} {
+		if *field.name == "" {
+			*field.name = *field.fallback
+		}
+	}
+	return m
+}
+
+// validate rejects the method names which are not namespace_method identifiers
+func (m RPCMethods) validate() error {
+	for _, name := range []string{m.ChainID, m.GetBlockByHash, m.GetBlockByNumber, m.GetBlockReceipts, m.GetBundledBlockByNumber, m.GetLogs, m.GetTransactionByHash, m.GetTransactionByIndex, m.GetTransactionReceipt, m.TraceBlockByNumber} {
+		if !rpcMethodName.MatchString(name) {
+			return fmt.Errorf("invalid block archiver rpc method name %q", name)
+		}
+	}
+	return nil
+}
+
+// WithRPCMethods overrides the names of the JSON-RPC methods called on the block archiver, the
+// empty fields keep their default name. New fails if a name isn't a valid method name.
+func WithRPCMethods(methods RPCMethods) Option {
+	return func(c *Client) {
+		c.methods = methods.merge(c.methods)
+	}
+}
@@ -0,0 +1,48 @@
+package blockarchiver
+
+import "github.com/ethereum/go-ethereum/metrics"
+
+var (
+	bundleFetchInflightGauge = metrics.NewRegisteredGauge("blockarchiver/bundle/inflight", nil)
+	bundleFetchQueuedGauge   = metrics.NewRegisteredGauge("blockarchiver/bundle/queued", nil)
+	bundleFetchWaitTimer     = metrics.NewRegisteredTimer("blockarchiver/bundle/wait", nil)
+	// bundleFetchPreemptionMeter counts the foreground fetches queued ahead of background ones
+	bundleFetchPreemptionMeter = metrics.NewRegisteredMeter("blockarchiver/bundle/preemption", nil)
+	// bundleResultHitMeter counts the bundles served from the recent fetch results
+	bundleResultHitMeter = metrics.NewRegisteredMeter("blockarchiver/bundle/result/hit", nil)
+	// bundleDecodeTimer measures the decoding of the downloaded bundles into blocks
+	bundleDecodeTimer        = metrics.NewRegisteredTimer("blockarchiver/bundle/decode", nil)
+	bundleDecodeTimeoutMeter = metrics.NewRegisteredMeter("blockarchiver/bundle/decode/timeout", nil)
+
+	requestInflightGauge   = metrics.NewRegisteredGauge("blockarchiver/request/inflight", nil)
+	requestOverloadedMeter = metrics.NewRegisteredMeter("blockarchiver/request/overloaded", nil)
+
+	lagBlocksGauge = metrics.NewRegisteredGauge("blockarchiver/lag/blocks", nil)
+	lagTimeGauge   = metrics.NewRegisteredGauge("blockarchiver/lag/time", nil)
+
+	// the hits and misses of the block caches looked up by GetBlockByNumber and GetBlockByHash
+	hashCacheHitMeter    = metrics.NewRegisteredMeter("blockarchiver/cache/hash/hit", nil)
+	hashCacheMissMeter   = metrics.NewRegisteredMeter("blockarchiver/cache/hash/miss", nil)
+	headerCacheHitMeter  = metrics.NewRegisteredMeter("blockarchiver/cache/header/hit", nil)
+	headerCacheMissMeter = metrics.NewRegisteredMeter("blockarchiver/cache/header/miss", nil)
+	bodyCacheHitMeter    = metrics.NewRegisteredMeter("blockarchiver/cache/body/hit", nil)
+	bodyCacheMissMeter   = metrics.NewRegisteredMeter("blockarchiver/cache/body/miss", nil)
+
+	bodyCacheBytesGauge    = metrics.NewRegisteredGauge("blockarchiver/cache/body/bytes", nil)
+	undersizedCacheCounter = metrics.NewRegisteredCounter("blockarchiver/cache/undersized", nil)
+	cacheCapacityGauge     = metrics.NewRegisteredGauge("blockarchiver/cache/capacity", nil)
+
+	// continuityCheckedMeter and continuitySkippedMeter count the continuity checks performed and
+	// skipped for the lack of a parent
+	continuityCheckedMeter = metrics.NewRegisteredMeter("blockarchiver/continuity/checked", nil)
+	continuitySkippedMeter = metrics.NewRegisteredMeter("blockarchiver/continuity/skipped", nil)
+)
+
+// markCacheLookup marks the hit or miss meter of a cache lookup
+func markCacheLookup(hit bool, hitMeter, missMeter metrics.Meter) {
+	if hit {
+		hitMeter.Mark(1)
+	} else {
+		missMeter.Mark(1)
+	}
+}
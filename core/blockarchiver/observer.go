@@ -0,0 +1,74 @@
+package blockarchiver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// RequestInfo describes an HTTP request made by the client, each attempt of a retried request is
+// reported on its own
+type RequestInfo struct {
+	// Method is the JSON-RPC method of the request, "batch" for a batch of requests, or the
+	// "bundle_name", "bundle_metadata" and "bundle" REST requests
+	Method string
+	// StatusCode is the HTTP status of the response, zero if no response was received
+	StatusCode int
+	// Bytes is the size of the response body read, zero for the failed requests
+	Bytes int
+	// Duration is the time spent from sending the request to reading its body
+	Duration time.Duration
+	Err      error
+}
+
+// Observer is notified of every HTTP request made by the client once it completed, e.g. to
+// export per method metrics. It is called synchronously from the requesting goroutine so it must
+// be fast and safe for concurrent use.
+type Observer interface {
+	ObserveRequest(info RequestInfo)
+}
+
+// noopObserver is the default observer, it ignores the requests
+type noopObserver struct{}
+
+func (noopObserver) ObserveRequest(RequestInfo) {}
+
+// WithObserver sets the observer notified of the requests of the client, nothing is observed by
+// default. See MetricsObserver for an observer recording the requests in the metrics registry.
+func WithObserver(observer Observer) Option {
+	return func(c *Client) {
+		if observer == nil {
+			observer = noopObserver{}
+		}
+		c.observer = observer
+	}
+}
+
+// MetricsObserver records the latency, the response size and the status of the requests in the
+// default metrics registry, labelled by method under blockarchiver/requests/<method>/. The
+// methods are the ones the client calls, so the number of metrics stays bounded.
+type MetricsObserver struct{}
+
+// ObserveRequest implements Observer
+func (MetricsObserver) ObserveRequest(info RequestInfo) {
+	prefix := fmt.Sprintf("blockarchiver/requests/%s/", info.Method)
+	metrics.GetOrRegisterTimer(prefix+"latency", nil).Update(info.Duration)
+	metrics.GetOrRegisterHistogram(prefix+"bytes", nil, metrics.NewExpDecaySample(1028, 0.015)).Update(int64(info.Bytes))
+	status := "error"
+	if info.StatusCode != 0 {
+		status = fmt.Sprint(info.StatusCode)
+	}
+	metrics.GetOrRegisterCounter(prefix+"status/"+status, nil).Inc(1)
+}
+
+// observe reports a completed request to the observer of the client
+func (c *Client) observe(method string, start time.Time, statusCode, bytes int, err error) {
+	c.observer.ObserveRequest(RequestInfo{
+		Method:     method,
+		StatusCode: statusCode,
+		Bytes:      bytes,
+		Duration:   time.Since(start),
+		Err:        err,
+	})
+}
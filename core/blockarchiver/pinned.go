@@ -0,0 +1,133 @@
+package blockarchiver
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var _ BlockArchiver = (*PinnedReader)(nil)
+
+// ErrAbovePin is returned for reads beyond the height a PinnedReader is pinned at
+var ErrAbovePin = errors.New("block above the pinned height")
+
+// PinnedReader is a view of a block archiver pinned at a fixed height, blocks above the pin are
+// rejected with ErrAbovePin so a batch job keeps a stable historical view while the chain grows.
+// Reads at or below the pin go through the wrapped archiver and its caches as usual.
+type PinnedReader struct {
+	archiver BlockArchiver
+	maxBlock uint64
+}
+
+// PinnedReader returns a view of the service pinned at maxBlock
+func (c *BlockArchiverService) PinnedReader(maxBlock uint64) *PinnedReader {
+	return NewPinnedReader(c, maxBlock)
+}
+
+// NewPinnedReader returns a view of the given archiver pinned at maxBlock
+func NewPinnedReader(archiver BlockArchiver, maxBlock uint64) *PinnedReader {
+	return &PinnedReader{archiver: archiver, maxBlock: maxBlock}
+}
+
+// MaxBlock returns the height the reader is pinned at
+func (r *PinnedReader) MaxBlock() uint64 {
+	return r.maxBlock
+}
+
+// check returns ErrAbovePin if the number is above the pin
+func (r *PinnedReader) check(number uint64) error {
+	if number > r.maxBlock {
+		return fmt.Errorf("%w: block %d, pinned at %d", ErrAbovePin, number, r.maxBlock)
+	}
+	return nil
+}
+
+// GetLatestBlock returns the block at the pinned height rather than the chain head. The total
+// difficulty is left nil since only the latest block query reports it.
+func (r *PinnedReader) GetLatestBlock() (*GeneralBlock, error) {
+	body, header, err := r.archiver.GetBlockByNumber(r.maxBlock)
+	if err != nil {
+		return nil, err
+	}
+	block, err := assembleBlock(header, body)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneralBlock{Block: block}, nil
+}
+
+// GetBlockByNumber returns the block by number if it is not above the pin
+func (r *PinnedReader) GetBlockByNumber(number uint64) (*types.Body, *types.Header, error) {
+	if err := r.check(number); err != nil {
+		return nil, nil, err
+	}
+	return r.archiver.GetBlockByNumber(number)
+}
+
+// GetBlockByHash returns the block by hash if it is not above the pin
+func (r *PinnedReader) GetBlockByHash(hash common.Hash) (*types.Body, *types.Header, error) {
+	body, header, err := r.archiver.GetBlockByHash(hash)
+	if err != nil || header == nil {
+		return body, header, err
+	}
+	if err := r.check(header.Number.Uint64()); err != nil {
+		return nil, nil, err
+	}
+	return body, header, nil
+}
+
+// GetLightBlockByNumber returns the light block by number if it is not above the pin
+func (r *PinnedReader) GetLightBlockByNumber(number uint64) (*LightBlock, error) {
+	if err := r.check(number); err != nil {
+		return nil, err
+	}
+	return r.archiver.GetLightBlockByNumber(number)
+}
+
+// GetBlockHashByNumber returns the block hash by number if it is not above the pin
+func (r *PinnedReader) GetBlockHashByNumber(number uint64) (common.Hash, error) {
+	if err := r.check(number); err != nil {
+		return common.Hash{}, err
+	}
+	return r.archiver.GetBlockHashByNumber(number)
+}
+
+// GetHeaderByNumber returns the header by number if it is not above the pin
+func (r *PinnedReader) GetHeaderByNumber(number uint64) (*types.Header, error) {
+	if err := r.check(number); err != nil {
+		return nil, err
+	}
+	return r.archiver.GetHeaderByNumber(number)
+}
+
+// GetHeaderByHash returns the header by hash if it is not above the pin
+func (r *PinnedReader) GetHeaderByHash(hash common.Hash) (*types.Header, error) {
+	header, err := r.archiver.GetHeaderByHash(hash)
+	if err != nil || header == nil {
+		return header, err
+	}
+	if err := r.check(header.Number.Uint64()); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// GetGenesis returns the genesis header, which is below any pin
+func (r *PinnedReader) GetGenesis() (*types.Header, error) {
+	return r.archiver.GetGenesis()
+}
+
+// GetHeadersByRange returns the headers in [from, to] if the whole range is not above the pin
+func (r *PinnedReader) GetHeadersByRange(from, to uint64) ([]*types.Header, error) {
+	if err := r.check(to); err != nil {
+		return nil, err
+	}
+	return r.archiver.GetHeadersByRange(from, to)
+}
+
+// Close is a no-op, the wrapped archiver is owned and closed by its creator
+func (r *PinnedReader) Close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package blockarchiver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPinnedReader(t *testing.T) {
+	blocks := newTestChain(t, 100, 3)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+	for _, wire := range blocks {
+		block, err := convertBlock(wire)
+		if err != nil {
+			t.Fatalf("failed to convert block: %v", err)
+		}
+		service.cacheBlock(block.Block)
+	}
+	pinned := service.PinnedReader(101)
+
+	if _, header, err := pinned.GetBlockByNumber(101); err != nil || header.Number.Uint64() != 101 {
+		t.Fatalf("failed to get block at the pin: %v", err)
+	}
+	if _, _, err := pinned.GetBlockByNumber(102); !errors.Is(err, ErrAbovePin) {
+		t.Errorf("want %v for a block above the pin, got %v", ErrAbovePin, err)
+	}
+	if _, err := pinned.GetBlockHashByNumber(102); !errors.Is(err, ErrAbovePin) {
+		t.Errorf("want %v for a hash above the pin, got %v", ErrAbovePin, err)
+	}
+	if _, err := pinned.GetLightBlockByNumber(102); !errors.Is(err, ErrAbovePin) {
+		t.Errorf("want %v for a light block above the pin, got %v", ErrAbovePin, err)
+	}
+	if _, err := pinned.GetHeadersByRange(100, 102); !errors.Is(err, ErrAbovePin) {
+		t.Errorf("want %v for a range crossing the pin, got %v", ErrAbovePin, err)
+	}
+	above, err := convertBlock(blocks[2])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	if _, _, err := pinned.GetBlockByHash(above.Hash()); !errors.Is(err, ErrAbovePin) {
+		t.Errorf("want %v for a hash lookup above the pin, got %v", ErrAbovePin, err)
+	}
+
+	// the latest block of a pinned view is the block at the pin, not the chain head
+	latest, err := pinned.GetLatestBlock()
+	if err != nil {
+		t.Fatalf("failed to get pinned latest block: %v", err)
+	}
+	if latest.NumberU64() != 101 || latest.Hash().Hex() != blocks[1].Hash {
+		t.Errorf("pinned latest block mismatch, want 101 %s, got %d %s", blocks[1].Hash, latest.NumberU64(), latest.Hash().Hex())
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != 0 {
+		t.Errorf("want pinned reads served from the cache, got %d requests", n)
+	}
+}
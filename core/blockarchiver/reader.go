@@ -0,0 +1,103 @@
+package blockarchiver
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ChainReader exposes the block archiver with the same read signatures as ethclient.Client, so
+// code written against ethclient can be pointed at the archiver with minimal changes. Like
+// ethclient, a nil block number means the latest block and ethereum.NotFound is returned for
+// unknown blocks.
+type ChainReader struct {
+	archiver BlockArchiver
+}
+
+// NewChainReader creates a ChainReader reading through the given block archiver
+func NewChainReader(archiver BlockArchiver) *ChainReader {
+	return &ChainReader{archiver: archiver}
+}
+
+// BlockByNumber returns the block with the given number, or the latest block if number is nil
+func (r *ChainReader) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if number == nil {
+		block, err := r.archiver.GetLatestBlock()
+		if err != nil {
+			return nil, err
+		}
+		return block.Block, nil
+	}
+	if number.Sign() < 0 || !number.IsUint64() {
+		return nil, errors.New("unsupported block number")
+	}
+	body, header, err := r.archiver.GetBlockByNumber(number.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	return assembleBlock(header, body)
+}
+
+// HeaderByNumber returns the header with the given number, or the latest header if number is nil
+func (r *ChainReader) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		block, err := r.BlockByNumber(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		return block.Header(), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if number.Sign() < 0 || !number.IsUint64() {
+		return nil, errors.New("unsupported block number")
+	}
+	return r.archiver.GetHeaderByNumber(number.Uint64())
+}
+
+// BlockByHash returns the block with the given hash
+func (r *ChainReader) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	body, header, err := r.archiver.GetBlockByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	return assembleBlock(header, body)
+}
+
+// HeaderByHash returns the header with the given hash
+func (r *ChainReader) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	header, err := r.archiver.GetHeaderByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
+
+// assembleBlock builds a full block out of a cached header and body
+func assembleBlock(header *types.Header, body *types.Body) (*types.Block, error) {
+	if header == nil || body == nil {
+		return nil, ethereum.NotFound
+	}
+	block := types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
+	if body.Withdrawals != nil {
+		block = block.WithWithdrawals(body.Withdrawals)
+	}
+	return block, nil
+}
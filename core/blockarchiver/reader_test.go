@@ -0,0 +1,63 @@
+package blockarchiver
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+func TestChainReader(t *testing.T) {
+	blocks := newTestChain(t, 100, 3)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+	reader := NewChainReader(service)
+
+	// the latest block is fetched from the archiver
+	latest, err := reader.BlockByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to get latest block: %v", err)
+	}
+	if latest.NumberU64() != 102 || latest.Hash().Hex() != blocks[2].Hash {
+		t.Errorf("latest block mismatch, want 102 %s, got %d %s", blocks[2].Hash, latest.NumberU64(), latest.Hash().Hex())
+	}
+	header, err := reader.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to get latest header: %v", err)
+	}
+	if header.Hash() != latest.Hash() {
+		t.Errorf("latest header mismatch, want %s, got %s", latest.Hash(), header.Hash())
+	}
+
+	// a specific number is served from the caches
+	block, err := convertBlock(blocks[1])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.bodyCache.Add(block.Hash(), block.Body())
+	service.headerCache.Add(block.Hash(), block.Header())
+	service.hashCache.Add(block.NumberU64(), block.Hash())
+
+	got, err := reader.BlockByNumber(context.Background(), big.NewInt(101))
+	if err != nil {
+		t.Fatalf("failed to get block 101: %v", err)
+	}
+	if got.Hash() != block.Hash() || len(got.Transactions()) != len(block.Transactions()) {
+		t.Errorf("block 101 mismatch, want %s, got %s", block.Hash(), got.Hash())
+	}
+	got, err = reader.BlockByHash(context.Background(), block.Hash())
+	if err != nil {
+		t.Fatalf("failed to get block by hash: %v", err)
+	}
+	if got.NumberU64() != 101 {
+		t.Errorf("block by hash mismatch, want 101, got %d", got.NumberU64())
+	}
+	if _, err := reader.BlockByNumber(context.Background(), big.NewInt(-1)); err == nil {
+		t.Error("want error for a negative block number, got nil")
+	}
+	if _, err := assembleBlock(nil, nil); !errors.Is(err, ethereum.NotFound) {
+		t.Errorf("want not found for a missing block, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package blockarchiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ErrRateLimited is matched by the errors returned for requests the archiver rejected with a 429
+var ErrRateLimited = errors.New("block archiver rate limited")
+
+// RateLimitError is returned when the archiver answers with 429 Too Many Requests and the retries
+// are exhausted. RetryAfter is the delay asked by its Retry-After header, zero if it sent none.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("block archiver rate limited, retry after %v", e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// parseRetryAfter returns the delay of a Retry-After header value, given either in seconds or as
+// an HTTP date. Missing, malformed or past values mean no delay.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// retriesKey is the context key of the per-call retry count override
+type retriesKey struct{}
+
+// WithRetryCount returns a context overriding the number of retries of the requests made with it,
+// e.g. zero for latency critical head polling or a large count for backfills, whatever the
+// default of the client set by WithRetries
+func WithRetryCount(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, retriesKey{}, retries)
+}
+
+// WithRetries sets the number of times a failed JSON-RPC request is retried, zero by default which
+// disables the retries. Only the network errors, the 5xx and the 429 responses are retried, the
+// delay between the attempts is decided by the backoff strategy of the endpoint, or by the
+// Retry-After header of a 429 if longer. Use WithRetryCount to override it per call.
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// WithRetryMaxElapsed bounds the time spent retrying a failed JSON-RPC request, the last error is
+// returned once the next backoff would exceed it. Zero, the default, only bounds the retries by
+// their count.
+func WithRetryMaxElapsed(elapsed time.Duration) Option {
+	return func(c *Client) {
+		c.retryMaxElapsed = elapsed
+	}
+}
+
+// retriesOf returns the number of retries of a request made with ctx
+func (c *Client) retriesOf(ctx context.Context) int {
+	if retries, ok := ctx.Value(retriesKey{}).(int); ok {
+		return retries
+	}
+	return c.retries
+}
+
+// retry calls fn until it succeeds, fails with a non retryable error or the retries are exhausted,
+// waiting for the backoff of the endpoint between the attempts. It gives up as soon as ctx is done
+// or the next attempt would start past the max elapsed time.
+func (c *Client) retry(ctx context.Context, e *endpoint, fn func() error) error {
+	retries := c.retriesOf(ctx)
+	start := c.clock.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > retries || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		delay := e.backoff.NextDelay(attempt)
+		var rateLimit *RateLimitError
+		if errors.As(err, &rateLimit) && rateLimit.RetryAfter > delay {
+			delay = rateLimit.RetryAfter
+		}
+		if c.retryMaxElapsed > 0 && time.Duration(c.clock.Now()-start)+delay > c.retryMaxElapsed {
+			return err
+		}
+		log.Debug("retrying block archiver request", "endpoint", e.name, "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-c.clock.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// unavailable wraps the errors worth retrying later with ErrArchiverUnavailable, the other errors
+// are returned as is
+func unavailable(err error) error {
+	if err == nil || !retryable(err) || errors.Is(err, ErrArchiverUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrArchiverUnavailable, err)
+}
+
+// retryable reports whether a failed request is worth retrying, i.e. it failed on the network,
+// with a server error or was rate limited. Client errors, cancellations and malformed responses
+// fail fast.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
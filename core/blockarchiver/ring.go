@@ -0,0 +1,182 @@
+package blockarchiver
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// HostStrategy decides the order the block archiver hosts are tried in for a request
+type HostStrategy int
+
+const (
+	// HostPrimary sends every request to the first host, the next ones are only tried when the
+	// hosts before them fail. It keeps the caches of the primary host warm.
+	HostPrimary HostStrategy = iota
+	// HostRoundRobin starts every request on the host after the one the previous request
+	// started on, spreading the load evenly
+	HostRoundRobin
+	// HostByBundle starts a request for block N on the host picked by hashing the bundle of N,
+	// see WithHostRing
+	HostByBundle
+)
+
+const (
+	// hostFailureThreshold is the number of consecutive failures after which a host is skipped
+	hostFailureThreshold = 3
+	// hostCooldown is how long a failing host is only tried after the healthy ones
+	hostCooldown = 30 * time.Second
+)
+
+// hostRing spreads the requests over several block archiver hosts. A request fails over to the
+// next hosts of the ring when its host can't be reached or fails with a server error, and a host
+// failing repeatedly is tried last for a while.
+type hostRing struct {
+	strategy HostStrategy
+	// span is the number of blocks of a bundle, used by HostByBundle
+	span  uint64
+	hosts []string
+	// next is the host the next request starts on, used by HostRoundRobin
+	next atomic.Uint64
+
+	requests []metrics.Timer
+	errors   []metrics.Counter
+
+	mu sync.Mutex
+	// failures counts the consecutive failures of every host, downUntil is when a host failing
+	// past the threshold is tried in order again
+	failures  []int
+	downUntil []mclock.AbsTime
+}
+
+func newHostRing(strategy HostStrategy, span uint64, hosts []string) *hostRing {
+	ring := &hostRing{
+		strategy:  strategy,
+		span:      span,
+		hosts:     hosts,
+		failures:  make([]int, len(hosts)),
+		downUntil: make([]mclock.AbsTime, len(hosts)),
+	}
+	for i := range hosts {
+		prefix := fmt.Sprintf("blockarchiver/host/%d/", i)
+		ring.requests = append(ring.requests, metrics.GetOrRegisterTimer(prefix+"requests", nil))
+		ring.errors = append(ring.errors, metrics.GetOrRegisterCounter(prefix+"errors", nil))
+	}
+	return ring
+}
+
+// WithHosts sends the JSON-RPC and bundle name requests to the given hosts, replacing the host
+// passed to New. The strategy decides the host a request starts on, a request then fails over to
+// the next hosts on connection errors and 5xx responses. A host failing several times in a row
+// is tried after the other ones for a while. The metrics of each host are registered under
+// blockarchiver/host/<index>/.
+func WithHosts(strategy HostStrategy, hosts ...string) Option {
+	return func(c *Client) {
+		if len(hosts) == 0 {
+			return
+		}
+		c.ring = newHostRing(strategy, 0, hosts)
+		c.blockArchiverHost = hosts[0]
+	}
+}
+
+// WithHostRing spreads the JSON-RPC and bundle name requests over the given hosts, replacing
+// the host passed to New. A request for block N goes to the host picked by hashing the bundle
+// of N, i.e. N/span, requests not tied to a block go to the first reachable host in order. The
+// metrics of each host are registered under blockarchiver/host/<index>/.
+func WithHostRing(span uint64, hosts ...string) Option {
+	return func(c *Client) {
+		if span == 0 || len(hosts) == 0 {
+			return
+		}
+		c.ring = newHostRing(HostByBundle, span, hosts)
+		c.blockArchiverHost = hosts[0]
+	}
+}
+
+// blockKey is the context key of the block number a request is routed by
+type blockKey struct{}
+
+// withBlock returns a context routing the requests made with it by the given block number
+func withBlock(ctx context.Context, number uint64) context.Context {
+	return context.WithValue(ctx, blockKey{}, number)
+}
+
+// order returns the indexes of the hosts in the order they are tried for a request made with
+// ctx, the hosts cooling down after repeated failures go last
+func (r *hostRing) order(ctx context.Context, now mclock.AbsTime) []int {
+	first := 0
+	switch r.strategy {
+	case HostRoundRobin:
+		first = int((r.next.Add(1) - 1) % uint64(len(r.hosts)))
+	case HostByBundle:
+		if number, ok := ctx.Value(blockKey{}).(uint64); ok {
+			var key [8]byte
+			binary.BigEndian.PutUint64(key[:], number/r.span)
+			h := fnv.New32a()
+			h.Write(key[:])
+			first = int(h.Sum32() % uint32(len(r.hosts)))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := make([]int, 0, len(r.hosts))
+	var down []int
+	for i := range r.hosts {
+		index := (first + i) % len(r.hosts)
+		if now < r.downUntil[index] {
+			down = append(down, index)
+			continue
+		}
+		order = append(order, index)
+	}
+	return append(order, down...)
+}
+
+// record accounts a finished request to a host, a host failing hostFailureThreshold times in a
+// row cools down for hostCooldown
+func (r *hostRing) record(index int, start time.Time, now mclock.AbsTime, err error) {
+	r.requests[index].UpdateSince(start)
+	if err != nil {
+		r.errors[index].Inc(1)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil || !retryable(err) {
+		r.failures[index] = 0
+		return
+	}
+	r.failures[index]++
+	if r.failures[index] >= hostFailureThreshold {
+		r.downUntil[index] = now.Add(hostCooldown)
+	}
+}
+
+// tryHosts calls fn with every host a request made with ctx may go to, in order, until it
+// succeeds, fails with an error another host wouldn't fix or ctx is done. Without a ring, or
+// with a per-call host override, fn is called once.
+func (c *Client) tryHosts(ctx context.Context, fn func(host string) error) error {
+	if host, ok := ctx.Value(hostKey{}).(string); ok && host != "" {
+		return fn(host)
+	}
+	if c.ring == nil {
+		return fn(c.blockArchiverHost)
+	}
+	var err error
+	for _, index := range c.ring.order(ctx, c.clock.Now()) {
+		start := time.Now()
+		err = fn(c.ring.hosts[index])
+		c.ring.record(index, start, c.clock.Now(), err)
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package blockarchiver
+
+import (
+	"context"
+	"sync"
+)
+
+// fetchPriority orders the waiters for a bundle fetch slot
+type fetchPriority int
+
+const (
+	// priorityForeground is used for fetches a caller is blocked on
+	priorityForeground fetchPriority = iota
+	// priorityBackground is used for fetches nobody waits for, e.g. prefetches and audits
+	priorityBackground
+)
+
+// fetchScheduler hands out a fixed number of bundle fetch slots. A freed slot always goes to the
+// oldest foreground waiter first, background waiters only get the slots no foreground waiter
+// asks for. Running fetches are never interrupted, a foreground request only jumps the queue.
+type fetchScheduler struct {
+	slots int
+
+	mu sync.Mutex
+	// free is the number of available slots, it is only positive when nobody waits
+	free    int
+	waiters [priorityBackground + 1][]chan struct{}
+}
+
+// newFetchScheduler creates a scheduler handing out the given number of slots
+func newFetchScheduler(slots int) *fetchScheduler {
+	return &fetchScheduler{slots: slots, free: slots}
+}
+
+// acquire blocks until a slot is granted or the context is done
+func (s *fetchScheduler) acquire(ctx context.Context, priority fetchPriority) error {
+	s.mu.Lock()
+	if s.free > 0 {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+	if priority == priorityForeground && len(s.waiters[priorityBackground]) > 0 {
+		bundleFetchPreemptionMeter.Mark(1)
+	}
+	ready := make(chan struct{})
+	s.waiters[priority] = append(s.waiters[priority], ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		removed := s.remove(priority, ready)
+		s.mu.Unlock()
+		if !removed {
+			// the slot was granted while giving up, hand it over to the next waiter
+			s.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release returns a slot, handing it over to the next waiter if any
+func (s *fetchScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for priority := range s.waiters {
+		if queue := s.waiters[priority]; len(queue) > 0 {
+			s.waiters[priority] = queue[1:]
+			close(queue[0])
+			return
+		}
+	}
+	s.free++
+}
+
+// remove drops a waiter from its queue, reporting false if it was already granted a slot
+func (s *fetchScheduler) remove(priority fetchPriority, ready chan struct{}) bool {
+	queue := s.waiters[priority]
+	for i, waiter := range queue {
+		if waiter == ready {
+			s.waiters[priority] = append(queue[:i:i], queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// inUse returns the number of slots currently granted
+func (s *fetchScheduler) inUse() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.slots - s.free
+}
+
+// queued returns the number of waiters of the given priority
+func (s *fetchScheduler) queued(priority fetchPriority) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiters[priority])
+}
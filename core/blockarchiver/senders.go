@@ -0,0 +1,49 @@
+package blockarchiver
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// senderCache keeps the senders recovered from the converted transactions by transaction hash,
+// so converting a bundle again after its blocks were evicted skips the signature recoveries
+type senderCache struct {
+	cache *lru.Cache[common.Hash, common.Address]
+}
+
+func newSenderCache(size int) *senderCache {
+	return &senderCache{cache: lru.NewCache[common.Hash, common.Address](size)}
+}
+
+// derive sets the sender of the transactions, recovering it only for the transactions unknown
+// to the cache. The sender is stored in the transactions the same way types.Sender does, so
+// later types.Sender calls with the latest signer of the chain don't recover it again.
+func (s *senderCache) derive(txs []*types.Transaction) error {
+	for _, tx := range txs {
+		signer := cachingSigner{Signer: types.LatestSignerForChainID(tx.ChainId()), senders: s}
+		if _, err := types.Sender(signer, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachingSigner is a signer consulting the sender cache before recovering a sender. It is equal
+// to the signer it wraps, hence the senders it derives are reused by types.Sender for that signer.
+type cachingSigner struct {
+	types.Signer
+	senders *senderCache
+}
+
+func (s cachingSigner) Sender(tx *types.Transaction) (common.Address, error) {
+	if from, ok := s.senders.cache.Get(tx.Hash()); ok {
+		return from, nil
+	}
+	from, err := s.Signer.Sender(tx)
+	if err != nil {
+		return common.Address{}, err
+	}
+	s.senders.cache.Add(tx.Hash(), from)
+	return from, nil
+}
@@ -0,0 +1,65 @@
+package blockarchiver
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestSenderCache(t *testing.T) {
+	blocks := newTestChain(t, 100, 2)
+	senders := newSenderCache(16)
+	for round := 0; round < 2; round++ {
+		for _, wire := range blocks {
+			block, err := convertBlock(wire)
+			if err != nil {
+				t.Fatalf("failed to convert block: %v", err)
+			}
+			if err := senders.derive(block.Transactions()); err != nil {
+				t.Fatalf("failed to derive senders: %v", err)
+			}
+			for i, tx := range block.Transactions() {
+				from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+				if err != nil || from != testAddr {
+					t.Errorf("transaction %d sender mismatch, want %s, got %s (%v)", i, testAddr, from, err)
+				}
+			}
+		}
+	}
+	// the test chain repeats the same transactions in every block
+	if n := senders.cache.Len(); n != len(newTestTransactions(t)) {
+		t.Errorf("want %d cached senders, got %d", len(newTestTransactions(t)), n)
+	}
+}
+
+// BenchmarkConvertBundleSenders converts the same bundle again and again with the senders
+// recovered, as happens when a bundle is fetched again after its blocks were evicted
+func BenchmarkConvertBundleSenders(b *testing.B) {
+	bundle := newTestChain(b, 100, 100)
+	convert := func(b *testing.B, derive func([]*types.Transaction) error) {
+		for i := 0; i < b.N; i++ {
+			for _, wire := range bundle {
+				block, err := convertBlock(wire)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := derive(block.Transactions()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.Run("uncached", func(b *testing.B) {
+		convert(b, func(txs []*types.Transaction) error {
+			for _, tx := range txs {
+				if _, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	b.Run("cached", func(b *testing.B) {
+		convert(b, newSenderCache(1024).derive)
+	})
+}
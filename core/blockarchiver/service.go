@@ -3,67 +3,285 @@ package blockarchiver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
+	// GetBlockTimeout is the default bound of the wait for a bundle fetched by another request
 	GetBlockTimeout = 5 * time.Second
 
 	RPCTimeout = 30 * time.Second
+
+	// MaxConcurrentBundleFetches is the number of bundles fetched from the block archiver at the same time
+	MaxConcurrentBundleFetches = 8
+	// DefaultMaxInflightRequests is the default number of cache misses served at the same time
+	DefaultMaxInflightRequests = 256
+
+	// receiptCacheLimit is the number of blocks whose receipts are cached by GetBlockAndReceipts
+	receiptCacheLimit = 1024
+
+	// recentBundles is the number of resolved bundles remembered by BundleFor
+	recentBundles = 128
+
+	// BlockInterval is the expected time between two blocks, used to turn a block gap into a time gap
+	BlockInterval = 3 * time.Second
+
+	// CloseTimeout bounds the time Close waits for the in-flight fetches to return
+	CloseTimeout = 10 * time.Second
 )
 
+// ErrOverloaded is returned when too many cache misses are already being served
+var ErrOverloaded = errors.New("block archiver overloaded")
+
+// ErrClosed is returned by the requests made after the service is closed
+var ErrClosed = errors.New("block archiver service closed")
+
+// ErrBlockNotFound is returned when the block archiver doesn't serve a requested block, it matches
+// ethereum.NotFound
+var ErrBlockNotFound = fmt.Errorf("block %w", ethereum.NotFound)
+
+// ErrMalformedBundle is returned when a bundle doesn't hold the blocks of the range its name claims
+var ErrMalformedBundle = errors.New("malformed bundle")
+
+// TransactionNotFoundError is returned for a transaction index out of the range of the
+// transactions of its block, it matches ethereum.NotFound
+type TransactionNotFoundError struct {
+	Number uint64
+	Index  uint64
+}
+
+func (e *TransactionNotFoundError) Error() string {
+	return fmt.Sprintf("transaction %d of block %d not found", e.Index, e.Number)
+}
+
+func (e *TransactionNotFoundError) Unwrap() error {
+	return ethereum.NotFound
+}
+
 var _ BlockArchiver = (*BlockArchiverService)(nil)
 
 type BlockArchiver interface {
 	GetLatestBlock() (*GeneralBlock, error)
 	GetBlockByNumber(number uint64) (*types.Body, *types.Header, error)
 	GetBlockByHash(hash common.Hash) (*types.Body, *types.Header, error)
+	GetLightBlockByNumber(number uint64) (*LightBlock, error)
+	GetBlockHashByNumber(number uint64) (common.Hash, error)
+	GetHeaderByNumber(number uint64) (*types.Header, error)
+	GetHeaderByHash(hash common.Hash) (*types.Header, error)
+	GetGenesis() (*types.Header, error)
+	GetHeadersByRange(from, to uint64) ([]*types.Header, error)
+	Close() error
 }
 
 type BlockArchiverService struct {
 	// client to interact with the block archiver service
 	client *Client
-	// injected from BlockChain, the specified block is always read and write simultaneously in bodyCache and headerCache.
+	// injected from BlockChain, a body is always written together with its header in headerCache, while
+	// headerCache may also hold header-only entries, e.g. from GetBlockHashByNumber, so a block is only
+	// served from the caches when it is found in both.
 	bodyCache *lru.Cache[common.Hash, *types.Body]
 	// injected from BlockChain.headerChain
 	headerCache *lru.Cache[common.Hash, *types.Header]
+	// receiptCache keeps the receipts verified by GetBlockAndReceipts by block hash
+	receiptCache *lru.Cache[common.Hash, []*types.Receipt]
 	// hashCache is a cache for block number to hash mapping
 	hashCache *lru.Cache[uint64, common.Hash]
+	// bundles keeps the recently resolved bundles by their first block
+	bundles *lru.Cache[uint64, *BundleInfo]
 	// requestLock is a lock to avoid concurrent fetching of the same bundle of blocks
 	requestLock *RequestLock
+	// fetchSlots caps the number of bundles fetched concurrently, serving blocked callers first
+	fetchSlots *fetchScheduler
+	// inflight is the number of cache misses being served, bounded by maxInflight
+	inflight    atomic.Int64
+	maxInflight int64
+	// verifyTxRoot checks the transactions and withdrawals of the fetched bundles against their headers
+	verifyTxRoot bool
+	// verifyHashes checks the blocks of the fetched bundles hash to the hashes reported for them
+	verifyHashes bool
+	// emptyBundleRetries is the number of times a bundle without blocks is fetched again
+	emptyBundleRetries int
+	// verifyContinuity checks the fetched blocks link to their parents, strictContinuity fetches
+	// the parents missing from the caches for the first block of the bundles
+	verifyContinuity bool
+	strictContinuity bool
+	// checkCompleteness rejects the fetched bundles not holding exactly the blocks of their range
+	checkCompleteness bool
+	// diskCache keeps the fetched bundles on disk, nil if disabled
+	diskCache *diskCache
+	// results keeps the blocks of the just fetched bundles, nil if disabled
+	results *bundleResults
+	// missing remembers the blocks the archiver recently failed to serve, nil if disabled
+	missing *missingBlocks
+	// sizeCheck reports the caches too small to hold a bundle
+	sizeCheck cacheSizeCheck
+	// bodyBudget bounds the bodyCache by the size of the bodies, nil if the cache is only bounded by count
+	bodyBudget *bodyBudget
+	// checkpoints are the trusted hashes of some blocks, checked whenever those blocks are fetched
+	checkpoints map[uint64]common.Hash
+	// senders caches the recovered senders of the converted transactions, nil if disabled
+	senders *senderCache
+	// adaptive sizes the block caches to the working set, nil if the caches have a fixed size
+	adaptive *adaptiveCapacity
+
+	// coverage caches the range of blocks served by the archiver, see checkCoverage
+	coverage coverage
+
+	// genesis is cached forever once fetched, since it never changes
+	genesis atomic.Pointer[types.Header]
+	// latest is the last fetched latest block, reused for latestTTL, latestGroup coalesces its refreshes
+	latestLock    sync.Mutex
+	latest        *GeneralBlock
+	latestUpdated mclock.AbsTime
+	latestTTL     time.Duration
+	latestGroup   singleflight.Group
+
+	// clock drives the TTLs and timeouts of the service, shared with the client
+	clock mclock.Clock
+
+	// shutdownCtx is the parent of the contexts of every request, it is cancelled by Close
+	shutdownCtx context.Context
+	shutdown    context.CancelFunc
+	// pending tracks the in-flight fetches Close waits for, no fetch starts once closing is set.
+	// pendingCount mirrors it for the error of Close.
+	pendingLock  sync.Mutex
+	closing      bool
+	pending      sync.WaitGroup
+	pendingCount atomic.Int64
+	closeTimeout time.Duration
+	// getBlockTimeout bounds the wait for a bundle fetched by another request, see awaitRange
+	getBlockTimeout time.Duration
+
+	quit      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
 }
 
-// NewBlockArchiverService creates a new block archiver service
-// the bodyCache and headerCache are injected from the BlockChain
-func NewBlockArchiverService(blockArchiver, sp, bucketName string,
+// NewBlockArchiverService creates a new block archiver service from the config
+// the bodyCache and headerCache are injected from the BlockChain, the options are passed to the client
+func NewBlockArchiverService(config *BlockArchiverConfig,
 	bodyCache *lru.Cache[common.Hash, *types.Body],
 	headerCache *lru.Cache[common.Hash, *types.Header],
-	cacheSize int,
+	opts ...Option,
 ) (BlockArchiver, error) {
-	client, err := New(blockArchiver, sp, bucketName)
+	if bodyCache == nil || headerCache == nil {
+		return nil, errors.New("block archiver service requires non-nil body and header caches")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	client, err := New(config.RPCAddress, config.SPAddress, config.BucketName, append(config.ClientOptions(), opts...)...)
 	if err != nil {
 		return nil, err
 	}
+	if config.ExpectedChainID != nil {
+		if err := checkChainID(client, config.ExpectedChainID); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
 	b := &BlockArchiverService{
-		client:      client,
-		bodyCache:   bodyCache,
-		headerCache: headerCache,
-		hashCache:   lru.NewCache[uint64, common.Hash](cacheSize),
-		requestLock: NewRequestLock(),
+		client:             client,
+		clock:              client.clock,
+		bodyCache:          bodyCache,
+		headerCache:        headerCache,
+		hashCache:          lru.NewCache[uint64, common.Hash](int(config.CacheCapacity())),
+		receiptCache:       lru.NewCache[common.Hash, []*types.Receipt](receiptCacheLimit),
+		bundles:            lru.NewCache[uint64, *BundleInfo](recentBundles),
+		requestLock:        NewRequestLock(),
+		fetchSlots:         newFetchScheduler(MaxConcurrentBundleFetches),
+		maxInflight:        int64(config.MaxInflightRequests),
+		verifyTxRoot:       config.VerifyTxRoot,
+		verifyHashes:       config.VerifyHashes,
+		emptyBundleRetries: config.EmptyBundleRetries,
+		checkCompleteness:  !config.SkipCompletenessCheck,
+		verifyContinuity:   config.VerifyContinuity || config.StrictContinuity,
+		strictContinuity:   config.StrictContinuity,
+		checkpoints:        config.TrustedCheckpoints,
+		closeTimeout:       CloseTimeout,
+		getBlockTimeout:    config.GetBlockTimeout,
+		quit:               make(chan struct{}),
+	}
+	b.shutdownCtx, b.shutdown = context.WithCancel(context.Background())
+	if b.maxInflight <= 0 {
+		b.maxInflight = DefaultMaxInflightRequests
+	}
+	if b.getBlockTimeout <= 0 {
+		b.getBlockTimeout = GetBlockTimeout
+	}
+	if config.DiskCacheDir != "" {
+		if b.diskCache, err = newDiskCache(config.DiskCacheDir, config.DiskCacheFormat); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if config.BodyCacheBytes > 0 {
+		b.bodyBudget = newBodyBudget(bodyCache, config.BodyCacheBytes)
+	}
+	switch {
+	case config.LatestBlockTTL == 0:
+		b.latestTTL = BlockInterval
+	case config.LatestBlockTTL > 0:
+		b.latestTTL = config.LatestBlockTTL
+	}
+	if config.BundleResultTTL > 0 {
+		b.results = newBundleResults(config.BundleResultTTL, b.clock)
+	}
+	if config.NotFoundTTL > 0 {
+		b.missing = newMissingBlocks(config.NotFoundTTL, b.clock)
+	}
+	if config.SenderCacheSize > 0 {
+		b.senders = newSenderCache(config.SenderCacheSize)
+	}
+	if config.MaxBlockCacheSize > config.BlockCacheSize {
+		b.adaptive = newAdaptiveCapacity(int(config.BlockCacheSize), int(config.MaxBlockCacheSize), b.evictBlock)
+	}
+	if config.CacheStatsInterval > 0 {
+		go b.cacheStats(config.CacheStatsInterval)
 	}
-	go b.cacheStats()
 	return b, nil
 }
 
-// GetLatestBlock returns the latest block
+// GetLatestBlock returns the latest block. The latest block is reused for latestTTL once fetched,
+// the concurrent refreshes are coalesced into a single request.
 func (c *BlockArchiverService) GetLatestBlock() (*GeneralBlock, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), RPCTimeout)
-	defer cancel()
+	if c.latestTTL > 0 {
+		c.latestLock.Lock()
+		block, updated := c.latest, c.latestUpdated
+		c.latestLock.Unlock()
+		if block != nil && c.clock.Now().Sub(updated) < c.latestTTL {
+			return block, nil
+		}
+	}
+	block, err, _ := c.latestGroup.Do("latest", func() (interface{}, error) {
+		return c.fetchLatestBlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return block.(*GeneralBlock), nil
+}
+
+// fetchLatestBlock fetches the latest block from the block archiver and keeps it for latestTTL
+func (c *BlockArchiverService) fetchLatestBlock() (*GeneralBlock, error) {
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	blockResp, err := c.client.GetLatestBlock(ctx)
 	if err != nil {
 		log.Error("failed to get latest block", "err", err)
@@ -74,9 +292,30 @@ func (c *BlockArchiverService) GetLatestBlock() (*GeneralBlock, error) {
 		log.Error("failed to convert block", "block", blockResp, "err", err)
 		return nil, err
 	}
+	c.latestLock.Lock()
+	c.latest, c.latestUpdated = block, c.clock.Now()
+	c.latestLock.Unlock()
 	return block, nil
 }
 
+// Lag returns how far the latest block of the block archiver is behind the given reference head,
+// in blocks and in time. The time gap is the distance between the timestamp of the archiver's
+// latest block and the timestamp the reference head is expected to have at BlockInterval. The
+// lag is zero when the archiver is at or ahead of the reference head.
+func (c *BlockArchiverService) Lag(referenceHead uint64) (blocks uint64, duration time.Duration, err error) {
+	latest, err := c.GetLatestBlock()
+	if err != nil {
+		return 0, 0, err
+	}
+	if number := latest.NumberU64(); number < referenceHead {
+		blocks = referenceHead - number
+		duration = time.Duration(blocks) * BlockInterval
+	}
+	lagBlocksGauge.Update(int64(blocks))
+	lagTimeGauge.Update(duration.Milliseconds())
+	return blocks, duration, nil
+}
+
 // GetLatestHeader returns the latest header
 func (c *BlockArchiverService) GetLatestHeader() (*types.Header, error) {
 	block, err := c.GetLatestBlock()
@@ -89,45 +328,511 @@ func (c *BlockArchiverService) GetLatestHeader() (*types.Header, error) {
 
 // GetBlockByNumber returns the block by number
 func (c *BlockArchiverService) GetBlockByNumber(number uint64) (*types.Body, *types.Header, error) {
+	return c.GetBlockByNumberContext(context.Background(), number)
+}
+
+// GetBlockByNumberContext returns the block by number like GetBlockByNumber, giving up with
+// ctx.Err() as soon as ctx is done while waiting for the bundle of the block to be fetched by
+// another request. The bundle fetches are shared by the waiting requests so they are not bound
+// by ctx.
+func (c *BlockArchiverService) GetBlockByNumberContext(ctx context.Context, number uint64) (*types.Body, *types.Header, error) {
 	log.Debug("get block by number", "number", number)
 	hash, found := c.hashCache.Get(number)
+	markCacheLookup(found, hashCacheHitMeter, hashCacheMissMeter)
 	if found {
 		log.Debug("GetBlockByNumber found in cache", number)
 		body, foundB := c.bodyCache.Get(hash)
 		header, foundH := c.headerCache.Get(hash)
+		markCacheLookup(foundB, bodyCacheHitMeter, bodyCacheMissMeter)
+		markCacheLookup(foundH, headerCacheHitMeter, headerCacheMissMeter)
 		if foundB && foundH {
+			if c.adaptive != nil {
+				c.adaptive.lookup(number, true)
+			}
 			return body, header, nil
 		}
 	}
-	return c.getBlockByNumber(number)
+	if c.adaptive != nil {
+		c.adaptive.lookup(number, false)
+	}
+	if c.missing == nil {
+		return c.getBlockByNumber(ctx, number)
+	}
+	if err := c.missing.get(number); err != nil {
+		return nil, nil, err
+	}
+	body, header, err := c.getBlockByNumber(ctx, number)
+	if isMissingBlock(err) {
+		c.missing.add(number, err)
+	}
+	return body, header, err
+}
+
+// isMissingBlock reports whether a block request failed because the archiver doesn't serve the
+// block, rather than for a transient failure
+func isMissingBlock(err error) bool {
+	return errors.Is(err, ErrNotArchived) || errors.Is(err, errNotFound) || errors.Is(err, ErrMalformedBundle)
+}
+
+// GetBlockAndReceipts returns the block by number together with its receipts. The receipts are
+// checked against the receipts root of the block header, so both always belong to the same
+// block even if they were fetched separately. The receipts are cached along with the block.
+func (c *BlockArchiverService) GetBlockAndReceipts(number uint64) (*types.Block, []*types.Receipt, error) {
+	body, header, err := c.GetBlockByNumber(number)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := assembleBlock(header, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if receipts, found := c.receiptCache.Get(block.Hash()); found {
+		return block, receipts, nil
+	}
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	raw, err := c.client.GetBlockReceipts(ctx, number)
+	if err != nil {
+		log.Error("failed to get block receipts", "number", number, "err", err)
+		return nil, nil, err
+	}
+	receipts, err := convertReceipts(raw)
+	if err != nil {
+		log.Error("failed to convert receipts", "number", number, "err", err)
+		return nil, nil, err
+	}
+	if err := verifyReceiptsRoot(header, receipts); err != nil {
+		log.Error("failed to verify block receipts", "number", number, "err", err)
+		return nil, nil, err
+	}
+	c.receiptCache.Add(block.Hash(), receipts)
+	return block, receipts, nil
+}
+
+// GetBlockReceipts returns the receipts of the block by number, checked against its receipts root
+// as done by GetBlockAndReceipts
+func (c *BlockArchiverService) GetBlockReceipts(number uint64) ([]*types.Receipt, error) {
+	_, receipts, err := c.GetBlockAndReceipts(number)
+	return receipts, err
+}
+
+// GetBlockReceiptsByHash returns the receipts of the block by hash, nil if the block is unknown
+func (c *BlockArchiverService) GetBlockReceiptsByHash(hash common.Hash) ([]*types.Receipt, error) {
+	if receipts, found := c.receiptCache.Get(hash); found {
+		return receipts, nil
+	}
+	_, header, err := c.GetBlockByHash(hash)
+	if err != nil || header == nil {
+		return nil, err
+	}
+	block, receipts, err := c.GetBlockAndReceipts(header.Number.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	if block.Hash() != hash {
+		return nil, fmt.Errorf("block %d is %s, not %s", header.Number, block.Hash(), hash)
+	}
+	return receipts, nil
+}
+
+// GetTransactionsByNumber returns the transactions of the block by number with their senders
+// already derived, so callers recovering the senders get them without another signature check.
+func (c *BlockArchiverService) GetTransactionsByNumber(number uint64) ([]*types.Transaction, error) {
+	body, _, err := c.GetBlockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	if c.senders != nil {
+		if err := c.senders.derive(body.Transactions); err != nil {
+			log.Error("failed to derive transaction senders", "number", number, "err", err)
+			return nil, err
+		}
+		return append([]*types.Transaction(nil), body.Transactions...), nil
+	}
+	txs := make([]*types.Transaction, 0, len(body.Transactions))
+	for _, tx := range body.Transactions {
+		// the sender is cached in the transaction, the chain id is taken from the transaction
+		// itself as the archiver serves a single chain
+		if _, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err != nil {
+			log.Error("failed to derive transaction sender", "number", number, "hash", tx.Hash(), "err", err)
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// GetTransactionByBlockNumberAndIndex returns the transaction at the given index of the block by
+// number, from the cached body if any, without fetching the whole bundle otherwise. It fails with
+// a *TransactionNotFoundError if the block has no transaction at that index.
+func (c *BlockArchiverService) GetTransactionByBlockNumberAndIndex(number uint64, index uint64) (*types.Transaction, error) {
+	if _, body, found := c.cachedBlock(number); found {
+		if index >= uint64(len(body.Transactions)) {
+			return nil, &TransactionNotFoundError{Number: number, Index: index}
+		}
+		return body.Transactions[index], nil
+	}
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	tx, err := c.client.GetTransactionByBlockNumberAndIndex(ctx, number, index)
+	if err != nil {
+		log.Debug("failed to get transaction by block number and index", "number", number, "index", index, "err", err)
+		return nil, err
+	}
+	return tx.ToTransaction()
+}
+
+// GetRawTransactionsByNumber returns the transactions of the block by number as served by the
+// block archiver, i.e. the wire structs with every field of their JSON representation, for
+// tooling comparing or re-exporting them. The wire transactions aren't cached, they are fetched
+// again from the archiver and checked to belong to the block known to the caches.
+func (c *BlockArchiverService) GetRawTransactionsByNumber(number uint64) ([]Transaction, error) {
+	hash, err := c.GetBlockHashByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	block, err := c.client.GetBlockByNumber(ctx, number)
+	if err != nil {
+		log.Error("failed to get block by number", "number", number, "err", err)
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("%w: number %d", ErrBlockNotFound, number)
+	}
+	if common.HexToHash(block.Hash) != hash {
+		return nil, fmt.Errorf("block %d hash mismatch, cached %s, archiver served %s", number, hash, block.Hash)
+	}
+	return block.Transactions, nil
+}
+
+// GetBlockMiner returns the coinbase of the block by number, i.e. the validator which sealed it.
+// Only the header is fetched on a cache miss.
+func (c *BlockArchiverService) GetBlockMiner(number uint64) (common.Address, error) {
+	hash, err := c.GetBlockHashByNumber(number)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if header, found := c.headerCache.Get(hash); found {
+		return header.Coinbase, nil
+	}
+	// the header was evicted in the meantime
+	_, header, err := c.GetBlockByNumber(number)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return header.Coinbase, nil
+}
+
+// GetBlockHashByNumber returns the canonical hash of the block by number. On a cache miss only
+// the header is fetched from the block archiver, which is much cheaper than a bundle fetch.
+func (c *BlockArchiverService) GetBlockHashByNumber(number uint64) (common.Hash, error) {
+	if hash, found := c.hashCache.Get(number); found {
+		return hash, nil
+	}
+	header, err := c.fetchHeaderByNumber(number)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return header.Hash(), nil
+}
+
+// GetHeaderByNumber returns the header of the block by number. On a cache miss only the header
+// is fetched from the block archiver, the body is neither downloaded nor cached.
+func (c *BlockArchiverService) GetHeaderByNumber(number uint64) (*types.Header, error) {
+	if hash, found := c.hashCache.Get(number); found {
+		header, found := c.headerCache.Get(hash)
+		markCacheLookup(found, headerCacheHitMeter, headerCacheMissMeter)
+		if found {
+			return header, nil
+		}
+	}
+	return c.fetchHeaderByNumber(number)
+}
+
+// GetHeaderByHash returns the header of the block by hash, nil if the archiver doesn't know the
+// block. On a cache miss only the header is fetched from the block archiver.
+func (c *BlockArchiverService) GetHeaderByHash(hash common.Hash) (*types.Header, error) {
+	header, found := c.headerCache.Get(hash)
+	markCacheLookup(found, headerCacheHitMeter, headerCacheMissMeter)
+	if found {
+		return header, nil
+	}
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	block, err := c.client.GetBlockHeaderByHash(ctx, hash)
+	if err != nil {
+		log.Error("failed to get block header by hash", "hash", hash, "err", err)
+		return nil, err
+	}
+	if block == nil {
+		log.Debug("block is nil", "hash", hash)
+		return nil, nil
+	}
+	header, err = convertVerifiedHeader(block)
+	if err != nil {
+		log.Error("failed to convert header", "hash", hash, "err", err)
+		return nil, err
+	}
+	if header.Hash() != hash {
+		return nil, fmt.Errorf("block %d hash mismatch, want %s, got %s", header.Number, hash, header.Hash())
+	}
+	if err := c.checkCheckpoint(header.Number.Uint64(), hash); err != nil {
+		return nil, err
+	}
+	c.headerCache.Add(hash, header)
+	c.hashCache.Add(header.Number.Uint64(), hash)
+	return header, nil
+}
+
+// fetchHeaderByNumber fetches the header of the block by number without its transactions and
+// adds it to headerCache and hashCache
+func (c *BlockArchiverService) fetchHeaderByNumber(number uint64) (*types.Header, error) {
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	block, err := c.client.GetBlockHeaderByNumber(ctx, number)
+	if err != nil {
+		log.Error("failed to get block header by number", "number", number, "err", err)
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("%w: number %d", ErrBlockNotFound, number)
+	}
+	header, err := convertVerifiedHeader(block)
+	if err != nil {
+		log.Error("failed to convert header", "number", number, "err", err)
+		return nil, err
+	}
+	hash := header.Hash()
+	if err := c.checkCheckpoint(number, hash); err != nil {
+		return nil, err
+	}
+	c.headerCache.Add(hash, header)
+	c.hashCache.Add(number, hash)
+	return header, nil
+}
+
+// GetGenesis returns the genesis header. It is fetched once and then kept for the lifetime of
+// the service, regardless of the cache sizes.
+func (c *BlockArchiverService) GetGenesis() (*types.Header, error) {
+	if genesis := c.genesis.Load(); genesis != nil {
+		return genesis, nil
+	}
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	block, err := c.client.GetBlockHeaderByNumber(ctx, 0)
+	if err != nil {
+		log.Error("failed to get genesis block", "err", err)
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("genesis %w", ErrBlockNotFound)
+	}
+	header, err := convertVerifiedHeader(block)
+	if err != nil {
+		log.Error("failed to convert genesis header", "err", err)
+		return nil, err
+	}
+	c.genesis.Store(header)
+	c.headerCache.Add(header.Hash(), header)
+	c.hashCache.Add(0, header.Hash())
+	return header, nil
+}
+
+// GetHeadersByRange returns the headers of the blocks in [from, to] in ascending order. The
+// headers are fetched in JSON-RPC batches and only populate headerCache and hashCache, bodyCache
+// is left untouched.
+func (c *BlockArchiverService) GetHeadersByRange(from, to uint64) ([]*types.Header, error) {
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	blocks, err := c.client.GetHeadersByRange(ctx, from, to)
+	if err != nil {
+		log.Error("failed to get headers by range", "from", from, "to", to, "err", err)
+		return nil, err
+	}
+	headers := make([]*types.Header, 0, len(blocks))
+	for _, block := range blocks {
+		header, err := convertVerifiedHeader(block)
+		if err != nil {
+			log.Error("failed to convert header", "number", block.Number, "err", err)
+			return nil, err
+		}
+		hash := header.Hash()
+		if err := c.checkCheckpoint(header.Number.Uint64(), hash); err != nil {
+			return nil, err
+		}
+		c.headerCache.Add(hash, header)
+		c.hashCache.Add(header.Number.Uint64(), hash)
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// GetBlocksByRange returns the blocks in [from, to] in ascending order, fetched in JSON-RPC batches
+// rather than bundle by bundle. The blocks are checked like the blocks of a bundle and seeded into
+// the body, header and hash caches, so it is a cheap way to warm the caches for a window.
+func (c *BlockArchiverService) GetBlocksByRange(from, to uint64) ([]*types.Block, error) {
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	fetched, err := c.client.GetBlocksByRange(ctx, from, to)
+	if err != nil {
+		log.Error("failed to get blocks by range", "from", from, "to", to, "err", err)
+		return nil, err
+	}
+	blocks := make([]*types.Block, 0, len(fetched))
+	var parent *types.Header
+	for _, b := range fetched {
+		block, err := convertBlock(b)
+		if err != nil {
+			log.Error("failed to convert block", "block", b, "err", err)
+			return nil, err
+		}
+		if want := common.HexToHash(b.Hash); block.Hash() != want {
+			log.Error("block hash mismatch", "number", block.NumberU64(), "expected", want, "actual", block.Hash())
+			return nil, fmt.Errorf("block %d hash mismatch, archiver reported %s, computed %s", block.NumberU64(), want, block.Hash())
+		}
+		if err := c.checkBlock(block.Block, parent); err != nil {
+			return nil, err
+		}
+		parent = block.Header()
+		blocks = append(blocks, block.Block)
+	}
+	// the blocks are only cached once the whole range passed the checks
+	for _, block := range blocks {
+		c.cacheBlock(block)
+	}
+	return blocks, nil
 }
 
-// getBlockByNumber returns the block by number
-func (c *BlockArchiverService) getBlockByNumber(number uint64) (*types.Body, *types.Header, error) {
+// GetBlockWithAncestors returns the headers of the block by number and of its depth-1 closest
+// ancestors, in ascending order, e.g. [N-2, N-1, N] for a depth of 3. The range is clamped at
+// the genesis block. Cached headers are used as is, otherwise the whole range is fetched with
+// GetHeadersByRange.
+func (c *BlockArchiverService) GetBlockWithAncestors(number uint64, depth int) ([]*types.Header, error) {
+	if depth <= 0 {
+		return nil, fmt.Errorf("invalid ancestor depth %d", depth)
+	}
+	from := uint64(0)
+	if uint64(depth) <= number {
+		from = number - uint64(depth) + 1
+	}
+	headers := make([]*types.Header, 0, number-from+1)
+	for n := from; n <= number; n++ {
+		hash, found := c.hashCache.Get(n)
+		if !found {
+			return c.GetHeadersByRange(from, number)
+		}
+		header, found := c.headerCache.Get(hash)
+		if !found {
+			return c.GetHeadersByRange(from, number)
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// GetLightBlockByNumber returns the header and the transaction count of the block by number,
+// the transactions are not decoded until LightBlock.Body is called. It is meant for scans that
+// only need header level data.
+//
+// A cache miss does not go through the bundle path, the single block is fetched from the block
+// archiver and only its header and number to hash mapping are cached, since the body is never
+// decoded here.
+func (c *BlockArchiverService) GetLightBlockByNumber(number uint64) (*LightBlock, error) {
+	hash, found := c.hashCache.Get(number)
+	if found {
+		body, foundB := c.bodyCache.Get(hash)
+		header, foundH := c.headerCache.Get(hash)
+		if foundB && foundH {
+			return &LightBlock{Header: header, TxCount: len(body.Transactions), body: body}, nil
+		}
+	}
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	block, err := c.client.GetBlockByNumber(ctx, number)
+	if err != nil {
+		log.Error("failed to get block by number", "number", number, "err", err)
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("%w: number %d", ErrBlockNotFound, number)
+	}
+	header, err := convertVerifiedHeader(block)
+	if err != nil {
+		log.Error("failed to convert header", "number", number, "err", err)
+		return nil, err
+	}
+	if err := checkUncles(header, block.Uncles); err != nil {
+		log.Error("invalid block uncles", "number", number, "err", err)
+		return nil, err
+	}
+	c.headerCache.Add(header.Hash(), header)
+	c.hashCache.Add(number, header.Hash())
+	return &LightBlock{Header: header, TxCount: len(block.Transactions), rawTxs: block.Transactions, rawWithdrawals: block.Withdrawals}, nil
+}
+
+// getBlockByNumber returns the block by number, callers beyond the in-flight limit are shed with
+// ErrOverloaded instead of piling up while the block archiver is slow. waitCtx only bounds the wait
+// for a bundle fetched by another request.
+func (c *BlockArchiverService) getBlockByNumber(waitCtx context.Context, number uint64) (*types.Body, *types.Header, error) {
+	if err := c.beginFetch(); err != nil {
+		return nil, nil, err
+	}
+	defer c.endFetch()
+	if err := c.acquireInflight(); err != nil {
+		log.Warn("shedding block request", "number", number, "limit", c.maxInflight)
+		return nil, nil, err
+	}
+	defer c.releaseInflight()
+
+	if err := c.checkCoverage(number); err != nil {
+		return nil, nil, err
+	}
 	// to avoid concurrent fetching of the same bundle of blocks, requestLock applies here
 	// if the number is within any of the ranges, should not fetch the bundle from the block archiver service but
 	// wait for a while and fetch from the cache
 	if c.requestLock.IsWithinAnyRange(number) {
 		log.Debug("getBlockByNumber is within any range", number)
 		if blockRange := c.requestLock.GetRangeForNumber(number); blockRange != nil {
-			select {
-			case <-blockRange.done:
-				hash, found := c.hashCache.Get(number)
-				if found {
-					body, foundB := c.bodyCache.Get(hash)
-					header, foundH := c.headerCache.Get(hash)
-					if foundB && foundH {
-						return body, header, nil
-					}
-				}
-			case <-time.After(GetBlockTimeout):
-				return nil, nil, errors.New("block not found")
+			if err := c.awaitRange(waitCtx, blockRange); err != nil {
+				return nil, nil, err
+			}
+			if header, body, ok := c.cachedBlock(number); ok {
+				return body, header, nil
 			}
 		}
 	}
 	// fetch the bundle range
 	log.Info("fetching bundle of blocks", "number", number)
-	ctx, cancel := context.WithTimeout(context.Background(), RPCTimeout)
+	ctx, cancel := context.WithTimeout(c.shutdownCtx, RPCTimeout)
 	defer cancel()
 
 	bundleName, err := c.client.GetBundleName(ctx, number)
@@ -141,19 +846,45 @@ func (c *BlockArchiverService) getBlockByNumber(number uint64) (*types.Body, *ty
 		log.Error("failed to parse bundle name", "bundleName", bundleName, "err", err)
 		return nil, nil, err
 	}
-	// add lock to avoid concurrent fetching of the same bundle of blocks
-	c.requestLock.AddRange(start, end)
+	c.bundles.Add(start, &BundleInfo{Name: bundleName, From: start, To: end})
+	// add lock to avoid concurrent fetching of the same bundle of blocks. The range is claimed only
+	// once the bundle is known, so a request which resolved the number elsewhere, e.g. by hash,
+	// joins a fetch of the bundle that started meanwhile instead of fetching it again.
+	for {
+		blockRange, claimed := c.requestLock.TryAddRange(start, end)
+		if claimed {
+			break
+		}
+		if err := c.awaitRange(waitCtx, blockRange); err != nil {
+			return nil, nil, err
+		}
+		if header, body, ok := c.cachedBlock(number); ok {
+			return body, header, nil
+		}
+	}
 	defer c.requestLock.RemoveRange(start, end)
-	ctx, cancel = context.WithTimeout(context.Background(), RPCTimeout)
+	// the bundle may have been fetched between the cache lookup and claiming its range
+	if header, body, ok := c.cachedBlock(number); ok {
+		return body, header, nil
+	}
+	ctx, cancel = context.WithTimeout(c.shutdownCtx, RPCTimeout)
 	defer cancel()
 
-	blocks, err := c.client.GetBundleBlocks(ctx, bundleName)
+	blocks, err := c.bundleBlocks(ctx, bundleName, start, end)
+	for retry := 0; err == nil && len(blocks) == 0 && retry < c.emptyBundleRetries; retry++ {
+		log.Warn("retrying empty bundle", "bundleName", bundleName, "retry", retry+1)
+		blocks, err = c.bundleBlocks(ctx, bundleName, start, end)
+	}
+	if err == nil && len(blocks) == 0 {
+		err = fmt.Errorf("%w: bundle %s has no blocks for range [%d, %d]", ErrMalformedBundle, bundleName, start, end)
+	}
 	if err != nil {
 		log.Error("failed to get bundle blocks", "bundleName", bundleName, "err", err)
 		return nil, nil, err
 	}
 	var body *types.Body
 	var header *types.Header
+	var parent *types.Header
 
 	log.Debug("populating block cache", "start", start, "end", end)
 	for _, b := range blocks {
@@ -162,27 +893,214 @@ func (c *BlockArchiverService) getBlockByNumber(number uint64) (*types.Body, *ty
 			log.Error("failed to convert block", "block", b, "err", err)
 			return nil, nil, err
 		}
-		c.bodyCache.Add(block.Hash(), block.Body())
-		c.headerCache.Add(block.Hash(), block.Header())
-		c.hashCache.Add(block.NumberU64(), block.Hash())
+		if c.verifyHashes {
+			if want := common.HexToHash(b.Hash); block.Hash() != want {
+				log.Error("block hash mismatch", "number", block.NumberU64(), "expected", want, "actual", block.Hash())
+				return nil, nil, fmt.Errorf("block %d hash mismatch, archiver reported %s, computed %s", block.NumberU64(), want, block.Hash())
+			}
+		}
+		if err := c.checkBlock(block.Block, parent); err != nil {
+			return nil, nil, err
+		}
+		parent = block.Header()
+		c.cacheBlock(block.Block)
 		if block.NumberU64() == number {
 			body = block.Body()
 			header = block.Header()
 		}
 	}
+	c.sizeCheck.observe(len(blocks), map[string]int{
+		"hash":   c.hashCache.Len(),
+		"header": c.headerCache.Len(),
+		"body":   c.bodyCache.Len(),
+	})
+	if body == nil || header == nil {
+		log.Error("bundle misses the requested block", "bundleName", bundleName, "number", number)
+		return nil, nil, fmt.Errorf("%w: bundle %s of range [%d, %d] misses block %d", ErrMalformedBundle, bundleName, start, end, number)
+	}
 	return body, header, nil
 }
 
+// checkBlock runs the configured checks of a fetched block before it is cached, deriving the
+// senders of its transactions on the way. The parent is the block fetched right before it, nil
+// for the first block of a bundle.
+func (c *BlockArchiverService) checkBlock(block *types.Block, parent *types.Header) error {
+	if c.verifyTxRoot {
+		if err := verifyBodyRoots(block); err != nil {
+			log.Error("failed to verify block body", "number", block.NumberU64(), "err", err)
+			return err
+		}
+	}
+	if c.senders != nil {
+		if err := c.senders.derive(block.Transactions()); err != nil {
+			log.Error("failed to derive transaction senders", "number", block.NumberU64(), "err", err)
+			return err
+		}
+	}
+	if err := c.checkCheckpoint(block.NumberU64(), block.Hash()); err != nil {
+		return err
+	}
+	if c.verifyContinuity {
+		if err := c.checkContinuity(parent, block.Header()); err != nil {
+			log.Error("failed to verify block continuity", "number", block.NumberU64(), "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitRange waits for the fetch of a bundle range by another request to be done, it returns
+// ctx.Err() as soon as ctx is done
+func (c *BlockArchiverService) awaitRange(ctx context.Context, blockRange *Range) error {
+	select {
+	case <-blockRange.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.clock.After(c.getBlockTimeout):
+		return fmt.Errorf("%w: timed out waiting for the bundle of range [%d, %d]", ErrBlockNotFound, blockRange.from, blockRange.to)
+	case <-c.shutdownCtx.Done():
+		return ErrClosed
+	}
+}
+
+// BundleFor returns the bundle holding the block by number, from the recently resolved bundles
+// or a fresh lookup, e.g. to inspect or re-request the bundle from the archiver
+func (c *BlockArchiverService) BundleFor(number uint64) (*BundleInfo, error) {
+	for _, from := range c.bundles.Keys() {
+		if bundle, ok := c.bundles.Peek(from); ok && bundle.From <= number && number <= bundle.To {
+			return bundle, nil
+		}
+	}
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	name, err := c.client.GetBundleName(ctx, number)
+	if err != nil {
+		log.Error("failed to get bundle name", "number", number, "err", err)
+		return nil, err
+	}
+	bundle, err := NewBundleInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	c.bundles.Add(bundle.From, bundle)
+	return bundle, nil
+}
+
+// BundleNameFor returns the name of the bundle holding the block by number, see BundleFor
+func (c *BlockArchiverService) BundleNameFor(number uint64) (string, error) {
+	bundle, err := c.BundleFor(number)
+	if err != nil {
+		return "", err
+	}
+	return bundle.Name, nil
+}
+
+// bundleBlocks returns the blocks of a bundle from the recent fetch results or the disk cache if
+// enabled, fetching them from the block archiver otherwise. An unreadable file is dropped and the
+// bundle fetched again. A fetched bundle not covering its range [start, end] is rejected before
+// it is cached, unless the completeness check is disabled.
+func (c *BlockArchiverService) bundleBlocks(ctx context.Context, bundleName string, start, end uint64) ([]*Block, error) {
+	if c.results != nil {
+		if blocks, ok := c.results.get(bundleName); ok {
+			bundleResultHitMeter.Mark(1)
+			return blocks, nil
+		}
+	}
+	if c.diskCache != nil {
+		blocks, err := c.diskCache.load(bundleName)
+		if err == nil && len(blocks) > 0 {
+			return blocks, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn("dropping unreadable bundle from the disk cache", "bundleName", bundleName, "err", err)
+			c.diskCache.remove(bundleName)
+		}
+	}
+	if err := c.acquireFetchSlot(ctx, priorityForeground); err != nil {
+		log.Error("failed to wait for a bundle fetch slot", "bundleName", bundleName, "err", err)
+		return nil, err
+	}
+	defer c.releaseFetchSlot()
+	blocks, err := c.client.GetBundleBlocks(ctx, bundleName)
+	if err != nil {
+		return nil, err
+	}
+	// an empty bundle is left to the caller, which may fetch it again
+	if len(blocks) > 0 && c.checkCompleteness {
+		if err := checkBundleCompleteness(bundleName, start, end, blocks); err != nil {
+			return nil, err
+		}
+	}
+	if c.diskCache != nil && len(blocks) > 0 {
+		if err := c.diskCache.store(bundleName, blocks); err != nil {
+			log.Warn("failed to write bundle to the disk cache", "bundleName", bundleName, "err", err)
+		}
+	}
+	if c.results != nil && len(blocks) > 0 {
+		c.results.add(bundleName, blocks)
+	}
+	return blocks, nil
+}
+
+// maxReportedNumbers bounds the block numbers listed in a completeness error
+const maxReportedNumbers = 16
+
+// checkBundleCompleteness fails with ErrMalformedBundle unless the blocks of the bundle are
+// exactly the blocks of its range [start, end], listing the missing and unexpected numbers
+func checkBundleCompleteness(bundleName string, start, end uint64, blocks []*Block) error {
+	numbers := make(map[uint64]struct{}, len(blocks))
+	var unexpected []uint64
+	for _, b := range blocks {
+		number, err := HexToUint64(b.Number)
+		if err != nil {
+			return fmt.Errorf("%w: bundle %s has a block with invalid number %q", ErrMalformedBundle, bundleName, b.Number)
+		}
+		if number < start || number > end {
+			unexpected = append(unexpected, number)
+		}
+		numbers[number] = struct{}{}
+	}
+	var missing []uint64
+	for n := start; n <= end; n++ {
+		if _, ok := numbers[n]; !ok {
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return nil
+	}
+	log.Error("incomplete bundle", "bundleName", bundleName, "missing", len(missing), "unexpected", len(unexpected))
+	return fmt.Errorf("%w: bundle %s of range [%d, %d] misses blocks %s, has unexpected blocks %s",
+		ErrMalformedBundle, bundleName, start, end, formatNumbers(missing), formatNumbers(unexpected))
+}
+
+// formatNumbers lists the numbers, eliding the ones past maxReportedNumbers
+func formatNumbers(numbers []uint64) string {
+	if len(numbers) <= maxReportedNumbers {
+		return fmt.Sprint(numbers)
+	}
+	return fmt.Sprintf("%v... (%d in total)", numbers[:maxReportedNumbers], len(numbers))
+}
+
 // GetBlockByHash returns the block by hash
 func (c *BlockArchiverService) GetBlockByHash(hash common.Hash) (*types.Body, *types.Header, error) {
 	log.Debug("get block by hash", "hash", hash.Hex())
 	body, foundB := c.bodyCache.Get(hash)
 	header, foundH := c.headerCache.Get(hash)
+	markCacheLookup(foundB, bodyCacheHitMeter, bodyCacheMissMeter)
+	markCacheLookup(foundH, headerCacheHitMeter, headerCacheMissMeter)
 	if foundB && foundH {
 		return body, header, nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), RPCTimeout)
-	defer cancel()
+	ctx, done, err := c.fetchContext()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
 	block, err := c.client.GetBlockByHash(ctx, hash)
 	if err != nil {
 		log.Error("failed to get block by hash", "hash", hash, "err", err)
@@ -192,16 +1110,188 @@ func (c *BlockArchiverService) GetBlockByHash(hash common.Hash) (*types.Body, *t
 		log.Debug("block is nil", "hash", hash)
 		return nil, nil, nil
 	}
-	number, err := HexToUint64(block.Number)
+	// the fetched block is checked and cached like the blocks of a bundle rather than fetching
+	// its bundle again
+	converted, err := convertBlock(block)
+	if err != nil {
+		log.Error("failed to convert block", "block", block, "err", err)
+		return nil, nil, err
+	}
+	if converted.Hash() != hash {
+		return nil, nil, fmt.Errorf("block %d hash mismatch, want %s, got %s", converted.NumberU64(), hash, converted.Hash())
+	}
+	if err := c.checkBlock(converted.Block, nil); err != nil {
+		return nil, nil, err
+	}
+	c.cacheBlock(converted.Block)
+	return converted.Body(), converted.Header(), nil
+}
+
+// checkChainID asks the block archiver for its chain id and fails if it isn't the expected one,
+// i.e. the archiver serves another network
+func checkChainID(client *Client, expected *big.Int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RPCTimeout)
+	defer cancel()
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block archiver chain id: %w", err)
+	}
+	if chainID.Cmp(expected) != 0 {
+		log.Error("block archiver serves another chain", "expected", expected, "archiver", chainID)
+		return fmt.Errorf("block archiver chain id mismatch, want %v, archiver reports %v", expected, chainID)
+	}
+	return nil
+}
+
+// checkCheckpoint compares the hash of a fetched block with its trusted checkpoint, if any. A
+// mismatch means the archiver serves another chain or has been tampered with.
+func (c *BlockArchiverService) checkCheckpoint(number uint64, hash common.Hash) error {
+	trusted, ok := c.checkpoints[number]
+	if !ok || trusted == hash {
+		return nil
+	}
+	log.Error("########## BLOCK ARCHIVER CHECKPOINT MISMATCH ##########")
+	log.Error("block archiver served a block not matching its trusted checkpoint, the archiver may be compromised",
+		"number", number, "trusted", trusted, "served", hash)
+	return fmt.Errorf("block %d checkpoint mismatch, trusted %s, archiver served %s", number, trusted, hash)
+}
+
+// cacheBlock adds a block to the body, header and hash caches, applying the body byte budget
+// if one is configured
+func (c *BlockArchiverService) cacheBlock(block *types.Block) {
+	if c.bodyBudget != nil {
+		c.bodyBudget.add(block.Hash(), block.Body())
+	} else {
+		c.bodyCache.Add(block.Hash(), block.Body())
+	}
+	c.headerCache.Add(block.Hash(), block.Header())
+	c.hashCache.Add(block.NumberU64(), block.Hash())
+	if c.adaptive != nil {
+		c.adaptive.add(block.NumberU64(), block.Hash())
+	}
+	if c.missing != nil {
+		c.missing.remove(block.NumberU64())
+	}
+}
+
+// evictBlock drops a block from the body, header and hash caches
+func (c *BlockArchiverService) evictBlock(number uint64, hash common.Hash) {
+	c.bodyCache.Remove(hash)
+	c.headerCache.Remove(hash)
+	c.hashCache.Remove(number)
+}
+
+// acquireInflight reserves an in-flight request, failing with ErrOverloaded once the limit is reached
+func (c *BlockArchiverService) acquireInflight() error {
+	if n := c.inflight.Add(1); n > c.maxInflight {
+		c.inflight.Add(-1)
+		requestOverloadedMeter.Mark(1)
+		return ErrOverloaded
+	}
+	requestInflightGauge.Inc(1)
+	return nil
+}
+
+// releaseInflight releases an in-flight request reserved by acquireInflight
+func (c *BlockArchiverService) releaseInflight() {
+	c.inflight.Add(-1)
+	requestInflightGauge.Dec(1)
+}
+
+// acquireFetchSlot blocks until a bundle fetch slot is available or the context is done, the
+// time spent waiting and the number of waiters are recorded as metrics. Foreground requests are
+// granted the freed slots before any background one.
+func (c *BlockArchiverService) acquireFetchSlot(ctx context.Context, priority fetchPriority) error {
+	start := time.Now()
+	bundleFetchQueuedGauge.Inc(1)
+	defer bundleFetchQueuedGauge.Dec(1)
+
+	err := c.fetchSlots.acquire(ctx, priority)
+	bundleFetchWaitTimer.UpdateSince(start)
 	if err != nil {
-		log.Error("failed to convert block number", "block", block, "err", err)
+		return err
+	}
+	bundleFetchInflightGauge.Inc(1)
+	return nil
+}
+
+// releaseFetchSlot returns a slot taken by acquireFetchSlot
+func (c *BlockArchiverService) releaseFetchSlot() {
+	c.fetchSlots.release()
+	bundleFetchInflightGauge.Dec(1)
+}
+
+// Close stops the background routines of the service and releases the connections of the client.
+// The in-flight fetches are cancelled and waited for up to closeTimeout, an error reports those
+// still pending past it. It is safe to call Close several times and concurrently, every call
+// returns the result of the first one.
+func (c *BlockArchiverService) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+		// refuse new fetches, then cancel the in-flight ones and wait for them to return so the
+		// client isn't closed under their feet
+		c.pendingLock.Lock()
+		c.closing = true
+		c.pendingLock.Unlock()
+		c.shutdown()
+
+		done := make(chan struct{})
+		go func() {
+			c.pending.Wait()
+			close(done)
+		}()
+		var waitErr error
+		select {
+		case <-done:
+		case <-c.clock.After(c.closeTimeout):
+			waitErr = fmt.Errorf("%d block archiver requests still pending after %v", c.pendingCount.Load(), c.closeTimeout)
+		}
+		c.closeErr = errors.Join(waitErr, c.client.Close())
+	})
+	return c.closeErr
+}
+
+// beginFetch registers an in-flight fetch Close waits for, failing with ErrClosed once closing
+func (c *BlockArchiverService) beginFetch() error {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	if c.closing {
+		return ErrClosed
+	}
+	c.pending.Add(1)
+	c.pendingCount.Add(1)
+	return nil
+}
+
+// endFetch unregisters a fetch registered by beginFetch
+func (c *BlockArchiverService) endFetch() {
+	c.pendingCount.Add(-1)
+	c.pending.Done()
+}
+
+// fetchContext registers a fetch like beginFetch and returns its context, bounded by RPCTimeout
+// and cancelled by Close. done must be called once the fetch is over.
+func (c *BlockArchiverService) fetchContext() (ctx context.Context, done func(), err error) {
+	if err := c.beginFetch(); err != nil {
 		return nil, nil, err
 	}
-	return c.getBlockByNumber(number)
+	ctx, cancel := context.WithTimeout(c.shutdownCtx, RPCTimeout)
+	return ctx, func() {
+		cancel()
+		c.endFetch()
+	}, nil
 }
 
-func (c *BlockArchiverService) cacheStats() {
-	for range time.NewTicker(1 * time.Minute).C {
-		log.Info("block archiver cache stats", "bodyCache", c.bodyCache.Len(), "headerCache", c.headerCache.Len(), "hashCache", c.hashCache.Len())
+// cacheStats logs the sizes of the block caches every interval until the service is closed
+func (c *BlockArchiverService) cacheStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Info("block archiver cache stats", "bodyCache", c.bodyCache.Len(), "headerCache", c.headerCache.Len(), "hashCache", c.hashCache.Len())
+		case <-c.quit:
+			return
+		}
 	}
 }
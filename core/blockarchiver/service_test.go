@@ -0,0 +1,1812 @@
+package blockarchiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bundlesdk "github.com/bnb-chain/greenfield-bundle-sdk/bundle"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testArchiver is a mock block archiver serving JSON-RPC block requests from memory
+type testArchiver struct {
+	blocks     map[uint64]*Block
+	receipts   map[uint64][]*Receipt
+	bundleSize uint64
+	finalized  uint64
+	// pageSize makes eth_getBundledBlockByNumber paginate its responses when non-zero
+	pageSize int
+	// aliases maps renamed methods to the method they are served as
+	aliases map[string]string
+	// bundleBytes enables the bundle metadata endpoint, reporting the given size, when non-zero
+	bundleBytes uint64
+	// bundleDelay delays serving the bundle objects, to keep bundle fetches in flight
+	bundleDelay time.Duration
+
+	mu     sync.Mutex
+	calls  map[string]int
+	params map[string][][]interface{}
+}
+
+func newTestArchiver(blocks ...*Block) *testArchiver {
+	a := &testArchiver{
+		blocks:     make(map[uint64]*Block),
+		receipts:   make(map[uint64][]*Receipt),
+		bundleSize: 10,
+		calls:      make(map[string]int),
+		params:     make(map[string][][]interface{}),
+	}
+	for _, b := range blocks {
+		number, _ := HexToUint64(b.Number)
+		a.blocks[number] = b
+	}
+	return a
+}
+
+// newTestChain returns count wire blocks starting from block number from
+func newTestChain(t testing.TB, from, count uint64) []*Block {
+	t.Helper()
+	txs := newTestTransactions(t)
+	blocks := make([]*Block, 0, count)
+	for i := uint64(0); i < count; i++ {
+		blocks = append(blocks, toWireBlock(newTestHeader(from+i, txs), txs))
+	}
+	return blocks
+}
+
+// bundleObject encodes the blocks from start to end as a greenfield bundle object
+func (a *testArchiver) bundleObject(start, end uint64) ([]byte, error) {
+	bundle, err := bundlesdk.NewBundle()
+	if err != nil {
+		return nil, err
+	}
+	defer bundle.Close()
+	for n := start; n <= end; n++ {
+		block, ok := a.blocks[n]
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(block)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := bundle.AppendObject(fmt.Sprintf("block_h%d", n), bytes.NewReader(data), nil); err != nil {
+			return nil, err
+		}
+	}
+	// the bundle sdk can't finalize a bundle without objects, an empty object is served instead
+	if len(bundle.GetBundleObjectsMeta()) == 0 {
+		return nil, nil
+	}
+	object, _, err := bundle.FinalizeBundle()
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(object)
+}
+
+// bundleRouter sends the bundle object requests, addressed to the bucket subdomain of the SP,
+// to the mock archiver, keeping the subdomain in the Host header
+type bundleRouter struct {
+	host string
+	next http.RoundTripper
+}
+
+func (r bundleRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Host, "bucket.") {
+		req = req.Clone(req.Context())
+		req.Host, req.URL.Host = req.URL.Host, r.host
+	}
+	return r.next.RoundTrip(req)
+}
+
+// serveBundles makes the service download its bundles from the mock archiver
+func serveBundles(service *BlockArchiverService) {
+	sp, _ := url.Parse(service.client.spHost)
+	service.client.hc.Transport = bundleRouter{host: sp.Host, next: service.client.hc.Transport}
+}
+
+// callCount returns how many times the given method or REST path was served
+func (a *testArchiver) callCount(method string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls[method]
+}
+
+// matchesLog reports whether a log matches the address and topics of an eth_getLogs filter
+func matchesLog(l Log, addresses, topics []interface{}) bool {
+	match := len(addresses) == 0
+	for _, address := range addresses {
+		match = match || strings.EqualFold(address.(string), l.Address)
+	}
+	for i, position := range topics {
+		alternatives, _ := position.([]interface{})
+		if len(alternatives) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range alternatives {
+			found = found || (i < len(l.Topics) && strings.EqualFold(topic.(string), l.Topics[i]))
+		}
+		match = match && found
+	}
+	return match
+}
+
+// callParams returns the params of every served request of the given method
+func (a *testArchiver) callParams(method string) [][]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.params[method]
+}
+
+func (a *testArchiver) record(method string, params []interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls[method]++
+	a.params[method] = append(a.params[method], params)
+}
+
+func (a *testArchiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.HasPrefix(r.Host, "bucket.") {
+		a.record("bundle/object", nil)
+		time.Sleep(a.bundleDelay)
+		start, end, err := ParseBundleName(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := a.bundleObject(start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/bundle/name") {
+		a.record("bundle/name", nil)
+		var number uint64
+		fmt.Sscanf(r.URL.Path, "/bsc/v1/blocks/%d/bundle/name", &number)
+		start := number - number%a.bundleSize
+		json.NewEncoder(w).Encode(GetBundleNameResponse{Data: fmt.Sprintf("blocks_s%d_e%d", start, start+a.bundleSize-1)})
+		return
+	}
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/bundle/metadata") {
+		a.record("bundle/metadata", nil)
+		if a.bundleBytes == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		var number uint64
+		fmt.Sscanf(r.URL.Path, "/bsc/v1/blocks/%d/bundle/metadata", &number)
+		start := number - number%a.bundleSize
+		fmt.Fprintf(w, `{"data":{"name":"blocks_s%d_e%d","blockCount":%d,"size":%d}}`, start, start+a.bundleSize-1, a.bundleSize, a.bundleBytes)
+		return
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(raw) > 0 && raw[0] == '[' {
+		var reqs []testRequest
+		if err := json.Unmarshal(raw, &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.record("batch", nil)
+		// answer in reverse order, batch responses are not required to follow the request order
+		resps := make([]interface{}, 0, len(reqs))
+		for i := len(reqs) - 1; i >= 0; i-- {
+			a.record(reqs[i].Method, reqs[i].Params)
+			resps = append(resps, a.handle(reqs[i].ID, reqs[i].Method, reqs[i].Params))
+		}
+		json.NewEncoder(w).Encode(resps)
+		return
+	}
+	var req testRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.record(req.Method, req.Params)
+	json.NewEncoder(w).Encode(a.handle(req.ID, req.Method, req.Params))
+}
+
+// testRequest is a single JSON-RPC request served by the testArchiver
+type testRequest struct {
+	ID     int64         `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// handle serves a single JSON-RPC request
+func (a *testArchiver) handle(id int64, method string, params []interface{}) interface{} {
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	if canonical, ok := a.aliases[method]; ok {
+		method = canonical
+	}
+	switch method {
+	case "eth_getBlockByNumber":
+		var block *Block
+		if tag, _ := params[0].(string); tag == "finalized" {
+			block = a.blocks[a.finalized]
+		} else if tag == "earliest" {
+			earliest := uint64(math.MaxUint64)
+			for number := range a.blocks {
+				if number <= earliest {
+					earliest = number
+				}
+			}
+			block = a.blocks[earliest]
+		} else if tag == "latest" {
+			var latest uint64
+			for number := range a.blocks {
+				if number >= latest {
+					latest = number
+				}
+			}
+			block = a.blocks[latest]
+		} else {
+			number, _ := HexToUint64(tag)
+			block = a.blocks[number]
+		}
+		if block == nil {
+			resp["result"] = nil
+		} else if full, _ := params[1].(string); full == "false" {
+			resp["result"] = withTxHashes(block)
+		} else {
+			resp["result"] = block
+		}
+	case "eth_getBlockReceipts":
+		param := params[0].(string)
+		if len(param) == 2+2*common.HashLength {
+			for number, block := range a.blocks {
+				if block.Hash == param {
+					resp["result"] = a.receipts[number]
+				}
+			}
+			break
+		}
+		number, _ := HexToUint64(param)
+		resp["result"] = a.receipts[number]
+	case "eth_getBlockByHash":
+		resp["result"] = nil
+		for _, block := range a.blocks {
+			if !strings.EqualFold(block.Hash, params[0].(string)) {
+				continue
+			}
+			if full, _ := params[1].(string); full == "false" {
+				resp["result"] = withTxHashes(block)
+			} else {
+				resp["result"] = block
+			}
+		}
+	case "eth_getTransactionByBlockNumberAndIndex":
+		resp["result"] = nil
+		number, _ := HexToUint64(params[0].(string))
+		index, _ := HexToUint64(params[1].(string))
+		if block, ok := a.blocks[number]; ok && index < uint64(len(block.Transactions)) {
+			resp["result"] = block.Transactions[index]
+		}
+	case "eth_getLogs":
+		filter := params[0].(map[string]interface{})
+		from, _ := HexToUint64(filter["fromBlock"].(string))
+		to, _ := HexToUint64(filter["toBlock"].(string))
+		addresses, _ := filter["address"].([]interface{})
+		topics, _ := filter["topics"].([]interface{})
+		logs := make([]Log, 0)
+		for number := from; number <= to; number++ {
+			for _, receipt := range a.receipts[number] {
+				for _, l := range receipt.Logs {
+					if matchesLog(l, addresses, topics) {
+						l.BlockNumber, l.BlockHash = receipt.BlockNumber, receipt.BlockHash
+						l.TransactionHash, l.TransactionIndex = receipt.TransactionHash, receipt.TransactionIndex
+						logs = append(logs, l)
+					}
+				}
+			}
+		}
+		resp["result"] = logs
+	case "eth_getTransactionReceipt":
+		resp["result"] = nil
+		for _, receipts := range a.receipts {
+			for _, receipt := range receipts {
+				if strings.EqualFold(receipt.TransactionHash, params[0].(string)) {
+					resp["result"] = receipt
+				}
+			}
+		}
+	case "eth_getTransactionByHash":
+		resp["result"] = nil
+		for _, block := range a.blocks {
+			for i := range block.Transactions {
+				if strings.EqualFold(block.Transactions[i].Hash, params[0].(string)) {
+					resp["result"] = block.Transactions[i]
+				}
+			}
+		}
+	case "eth_chainId":
+		resp["result"] = hexutil.EncodeBig(testChainID)
+	case "eth_getBundledBlockByNumber":
+		number, _ := HexToUint64(params[0].(string))
+		bundle := a.bundle(number)
+		if a.pageSize > 0 {
+			offset := 0
+			if len(params) > 1 {
+				offset, _ = strconv.Atoi(params[1].(string))
+			}
+			end := offset + a.pageSize
+			if end < len(bundle) {
+				resp["nextPageToken"] = strconv.Itoa(end)
+			} else {
+				end = len(bundle)
+			}
+			bundle = bundle[offset:end]
+		}
+		resp["result"] = bundle
+	default:
+		resp["error"] = &JsonError{Code: -32601, Message: fmt.Sprintf("the method %s does not exist", method)}
+	}
+	return resp
+}
+
+// bundle returns the blocks of the bundle holding the given number in ascending order
+func (a *testArchiver) bundle(number uint64) []*Block {
+	start := number - number%a.bundleSize
+	blocks := make([]*Block, 0, a.bundleSize)
+	for n := start; n < start+a.bundleSize; n++ {
+		if block, ok := a.blocks[n]; ok {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// withTxHashes strips the transaction details from a block, like eth_getBlockByNumber does when
+// the full transactions flag is false
+func withTxHashes(block *Block) *BlockWithTxHashes {
+	b := &BlockWithTxHashes{Block: *block, Transactions: make([]string, 0, len(block.Transactions))}
+	b.Block.Transactions = nil
+	for _, tx := range block.Transactions {
+		b.Transactions = append(b.Transactions, tx.Hash)
+	}
+	return b
+}
+
+// newTestService creates a block archiver service backed by the given mock archiver
+func newTestService(t *testing.T, archiver *testArchiver) *BlockArchiverService {
+	t.Helper()
+	server := httptest.NewServer(archiver)
+	t.Cleanup(server.Close)
+
+	config := &BlockArchiverConfig{
+		RPCAddress:     server.URL,
+		SPAddress:      server.URL,
+		BucketName:     "bucket",
+		BlockCacheSize: 100,
+	}
+	service, err := NewBlockArchiverService(config,
+		lru.NewCache[common.Hash, *types.Body](100),
+		lru.NewCache[common.Hash, *types.Header](100),
+	)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	t.Cleanup(func() { service.Close() })
+	return service.(*BlockArchiverService)
+}
+
+func TestNewBlockArchiverServiceNilCaches(t *testing.T) {
+	config := &BlockArchiverConfig{RPCAddress: "http://127.0.0.1", SPAddress: "http://127.0.0.1", BucketName: "bucket", BlockCacheSize: 100}
+	bodyCache := lru.NewCache[common.Hash, *types.Body](100)
+	headerCache := lru.NewCache[common.Hash, *types.Header](100)
+	if _, err := NewBlockArchiverService(config, nil, headerCache); err == nil {
+		t.Error("want error for a nil body cache, got nil")
+	}
+	if _, err := NewBlockArchiverService(config, bodyCache, nil); err == nil {
+		t.Error("want error for a nil header cache, got nil")
+	}
+}
+
+func TestExpectedChainID(t *testing.T) {
+	archiver := newTestArchiver()
+	server := httptest.NewServer(archiver)
+	defer server.Close()
+
+	config := &BlockArchiverConfig{RPCAddress: server.URL, SPAddress: server.URL, BucketName: "bucket", BlockCacheSize: 100}
+	newService := func(chainID *big.Int) (BlockArchiver, error) {
+		config.ExpectedChainID = chainID
+		return NewBlockArchiverService(config, lru.NewCache[common.Hash, *types.Body](100), lru.NewCache[common.Hash, *types.Header](100))
+	}
+	// the check is skipped when no chain id is expected
+	service, err := newService(nil)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	service.Close()
+	if n := archiver.callCount("eth_chainId"); n != 0 {
+		t.Errorf("want no chain id request, got %d", n)
+	}
+	service, err = newService(testChainID)
+	if err != nil {
+		t.Fatalf("failed to create service for the archiver chain: %v", err)
+	}
+	service.Close()
+	// an archiver serving the testnet is rejected
+	if _, err := newService(big.NewInt(97)); err == nil || !strings.Contains(err.Error(), "chain id mismatch") {
+		t.Errorf("want chain id mismatch, got %v", err)
+	}
+}
+
+func TestGetBlockHashByNumber(t *testing.T) {
+	blocks := newTestChain(t, 100, 3)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	for _, block := range blocks {
+		number, _ := HexToUint64(block.Number)
+		hash, err := service.GetBlockHashByNumber(number)
+		if err != nil {
+			t.Fatalf("failed to get hash of block %d: %v", number, err)
+		}
+		if hash != common.HexToHash(block.Hash) {
+			t.Fatalf("block %d hash mismatch, want %s, got %s", number, block.Hash, hash)
+		}
+		if _, found := service.headerCache.Get(hash); !found {
+			t.Errorf("block %d header not cached", number)
+		}
+	}
+	// the second lookup must be served from the cache
+	if _, err := service.GetBlockHashByNumber(100); err != nil {
+		t.Fatalf("failed to get cached hash: %v", err)
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != len(blocks) {
+		t.Errorf("want %d header requests, got %d", len(blocks), n)
+	}
+	for _, params := range archiver.callParams("eth_getBlockByNumber") {
+		if len(params) != 2 || params[1] != "false" {
+			t.Errorf("want header request without transaction details, got params %v", params)
+		}
+	}
+	if n := archiver.callCount("bundle/name"); n != 0 {
+		t.Errorf("want no bundle requests, got %d", n)
+	}
+	if service.bodyCache.Len() != 0 {
+		t.Errorf("want empty body cache, got %d entries", service.bodyCache.Len())
+	}
+}
+
+func TestGetHeaderByNumberAndHash(t *testing.T) {
+	blocks := newTestChain(t, 100, 2)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	header, err := service.GetHeaderByNumber(100)
+	if err != nil {
+		t.Fatalf("failed to get header by number: %v", err)
+	}
+	if header.Hash() != common.HexToHash(blocks[0].Hash) {
+		t.Fatalf("header hash mismatch, want %s, got %s", blocks[0].Hash, header.Hash())
+	}
+	hash := common.HexToHash(blocks[1].Hash)
+	header, err = service.GetHeaderByHash(hash)
+	if err != nil {
+		t.Fatalf("failed to get header by hash: %v", err)
+	}
+	if header.Hash() != hash {
+		t.Fatalf("header hash mismatch, want %s, got %s", hash, header.Hash())
+	}
+	// the second lookups must be served from the cache
+	if _, err := service.GetHeaderByNumber(101); err != nil {
+		t.Fatalf("failed to get cached header: %v", err)
+	}
+	if _, err := service.GetHeaderByHash(common.HexToHash(blocks[0].Hash)); err != nil {
+		t.Fatalf("failed to get cached header: %v", err)
+	}
+	if n := archiver.callCount("eth_getBlockByNumber") + archiver.callCount("eth_getBlockByHash"); n != 2 {
+		t.Errorf("want 2 header requests, got %d", n)
+	}
+	for _, method := range []string{"eth_getBlockByNumber", "eth_getBlockByHash"} {
+		for _, params := range archiver.callParams(method) {
+			if len(params) != 2 || params[1] != "false" {
+				t.Errorf("want %s without transaction details, got params %v", method, params)
+			}
+		}
+	}
+	if n := archiver.callCount("bundle/name"); n != 0 {
+		t.Errorf("want no bundle requests, got %d", n)
+	}
+	if service.bodyCache.Len() != 0 {
+		t.Errorf("want empty body cache, got %d entries", service.bodyCache.Len())
+	}
+	// unknown hashes are reported as nil headers like GetBlockByHash
+	if header, err := service.GetHeaderByHash(common.Hash{0x01}); err != nil || header != nil {
+		t.Errorf("want nil header for unknown hash, got %v, %v", header, err)
+	}
+}
+
+func TestGetBlocksByRangeSeedsCaches(t *testing.T) {
+	blocks := newTestChain(t, 100, 5)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	fetched, err := service.GetBlocksByRange(101, 103)
+	if err != nil {
+		t.Fatalf("failed to get blocks by range: %v", err)
+	}
+	if len(fetched) != 3 {
+		t.Fatalf("want 3 blocks, got %d", len(fetched))
+	}
+	for i, block := range fetched {
+		if block.Hash() != common.HexToHash(blocks[i+1].Hash) {
+			t.Errorf("block %d hash mismatch, want %s, got %s", 101+i, blocks[i+1].Hash, block.Hash())
+		}
+	}
+	// the blocks of the range are then served from the caches
+	for number := uint64(101); number <= 103; number++ {
+		if _, _, err := service.GetBlockByNumber(number); err != nil {
+			t.Fatalf("failed to get block %d: %v", number, err)
+		}
+	}
+	if n := archiver.callCount("bundle/name"); n != 0 {
+		t.Errorf("want no bundle requests, got %d", n)
+	}
+}
+
+func TestGetBlockMiner(t *testing.T) {
+	blocks := newTestChain(t, 100, 2)
+	// archivers may serve the miner in any case
+	blocks[1].Miner = "0x" + strings.ToUpper(blocks[1].Miner[2:])
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	for i, block := range blocks {
+		number := uint64(100 + i)
+		miner, err := service.GetBlockMiner(number)
+		if err != nil {
+			t.Fatalf("block %d: failed to get miner: %v", number, err)
+		}
+		if miner != testMiner {
+			t.Errorf("block %d: miner mismatch, want %s, got %s", number, testMiner, miner)
+		}
+		header, found := service.headerCache.Get(common.HexToHash(block.Hash))
+		if !found || header.Hash().Hex() != block.Hash {
+			t.Errorf("block %d: want cached header hashing to %s", number, block.Hash)
+		}
+	}
+	if n := archiver.callCount("eth_getBundledBlockByNumber"); n != 0 {
+		t.Errorf("want no bundle fetch, got %d", n)
+	}
+
+	// a malformed miner is rejected
+	for _, miner := range []string{testMiner.Hex()[:40], testMiner.Hex() + "00", "0x" + strings.Repeat("zz", 20)} {
+		blocks := newTestChain(t, 200, 1)
+		blocks[0].Miner = miner
+		if _, err := newTestService(t, newTestArchiver(blocks...)).GetBlockMiner(200); err == nil {
+			t.Errorf("miner %q: want error, got nil", miner)
+		}
+	}
+}
+
+func TestGetBlockHashByNumberMismatch(t *testing.T) {
+	blocks := newTestChain(t, 100, 1)
+	blocks[0].Hash = common.Hash{0x01}.Hex()
+	service := newTestService(t, newTestArchiver(blocks...))
+
+	if _, err := service.GetBlockHashByNumber(100); err == nil {
+		t.Fatal("want hash mismatch error, got nil")
+	}
+	if service.hashCache.Len() != 0 || service.headerCache.Len() != 0 {
+		t.Errorf("mismatched header cached, hashCache %d, headerCache %d", service.hashCache.Len(), service.headerCache.Len())
+	}
+}
+
+func TestGetLightBlockByNumberCached(t *testing.T) {
+	blocks := newTestChain(t, 100, 1)
+	archiver := newTestArchiver()
+	service := newTestService(t, archiver)
+
+	block, err := convertBlock(blocks[0])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.bodyCache.Add(block.Hash(), block.Body())
+	service.headerCache.Add(block.Hash(), block.Header())
+	service.hashCache.Add(block.NumberU64(), block.Hash())
+
+	light, err := service.GetLightBlockByNumber(100)
+	if err != nil {
+		t.Fatalf("failed to get light block: %v", err)
+	}
+	if light.TxCount != len(block.Transactions()) {
+		t.Errorf("transaction count mismatch, want %d, got %d", len(block.Transactions()), light.TxCount)
+	}
+	if light.body == nil {
+		t.Errorf("cached body not attached to the light block")
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != 0 {
+		t.Errorf("want no block requests, got %d", n)
+	}
+}
+
+func TestGetLightBlockByNumberDecode(t *testing.T) {
+	txs := newTestTransactions(t)
+	preShanghai := newTestHeader(100, txs)
+	postShanghai := newTestHeader(101, txs)
+	postShanghai.WithdrawalsHash = &types.EmptyWithdrawalsHash
+
+	blocks := []*Block{toWireBlock(preShanghai, txs), toWireBlock(postShanghai, txs)}
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	for _, wire := range blocks {
+		number, _ := HexToUint64(wire.Number)
+		light, err := service.GetLightBlockByNumber(number)
+		if err != nil {
+			t.Fatalf("failed to get light block %d: %v", number, err)
+		}
+		if light.TxCount != len(txs) {
+			t.Errorf("block %d transaction count mismatch, want %d, got %d", number, len(txs), light.TxCount)
+		}
+		if light.body != nil {
+			t.Errorf("block %d body decoded before it was requested", number)
+		}
+		if hash, found := service.hashCache.Get(number); !found || hash != light.Header.Hash() {
+			t.Errorf("block %d hash not cached", number)
+		}
+		if _, found := service.headerCache.Get(light.Header.Hash()); !found {
+			t.Errorf("block %d header not cached", number)
+		}
+		body, err := light.Body()
+		if err != nil {
+			t.Fatalf("failed to decode block %d body: %v", number, err)
+		}
+		want, err := convertBlock(wire)
+		if err != nil {
+			t.Fatalf("failed to convert block %d: %v", number, err)
+		}
+		if len(body.Transactions) != len(want.Transactions()) {
+			t.Fatalf("block %d transaction count mismatch, want %d, got %d", number, len(want.Transactions()), len(body.Transactions))
+		}
+		for i, tx := range body.Transactions {
+			if tx.Hash() != want.Transactions()[i].Hash() {
+				t.Errorf("block %d transaction %d hash mismatch", number, i)
+			}
+		}
+		if (body.Withdrawals == nil) != (want.Withdrawals() == nil) || len(body.Withdrawals) != len(want.Withdrawals()) {
+			t.Errorf("block %d withdrawals mismatch, want %v, got %v", number, want.Withdrawals(), body.Withdrawals)
+		}
+		if types.NewBlockWithHeader(light.Header).WithBody(body.Transactions, body.Uncles).Hash() != want.Hash() {
+			t.Errorf("block %d hash mismatch", number)
+		}
+	}
+}
+
+func TestCachedBodyWithdrawals(t *testing.T) {
+	txs := newTestTransactions(t)
+	withdrawals := []*types.Withdrawal{
+		{Index: 7, Validator: 11, Address: testMiner, Amount: 32000000000},
+		{Index: 8, Validator: 12, Address: testAddr, Amount: 1},
+	}
+	header := newTestHeader(100, txs)
+	withdrawalsHash := types.DeriveSha(types.Withdrawals(withdrawals), trie.NewStackTrie(nil))
+	header.WithdrawalsHash = &withdrawalsHash
+	wire := toWireBlock(header, txs)
+	wire.Withdrawals = toWireWithdrawals(withdrawals)
+
+	archiver := newTestArchiver()
+	service := newTestService(t, archiver)
+	block, err := convertBlock(wire)
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(block.Block)
+
+	full, err := NewChainReader(service).BlockByNumber(context.Background(), big.NewInt(100))
+	if err != nil {
+		t.Fatalf("failed to read cached block: %v", err)
+	}
+	if n := archiver.callCount("bundle/name"); n != 0 {
+		t.Fatalf("want block served from the cache, got %d bundle requests", n)
+	}
+	if full.Hash() != header.Hash() {
+		t.Errorf("block hash mismatch, want %s, got %s", header.Hash(), full.Hash())
+	}
+	if len(full.Withdrawals()) != len(withdrawals) {
+		t.Fatalf("want %d withdrawals, got %d", len(withdrawals), len(full.Withdrawals()))
+	}
+	for i, w := range full.Withdrawals() {
+		if *w != *withdrawals[i] {
+			t.Errorf("withdrawal %d mismatch, want %+v, got %+v", i, withdrawals[i], w)
+		}
+	}
+	if hash := types.DeriveSha(full.Withdrawals(), trie.NewStackTrie(nil)); hash != withdrawalsHash {
+		t.Errorf("withdrawals root mismatch, want %s, got %s", withdrawalsHash, hash)
+	}
+}
+
+func TestLightBlockBodyError(t *testing.T) {
+	txs := newTestTransactions(t)
+	wire := toWireBlock(newTestHeader(100, txs), txs)
+	wire.Transactions[1].Nonce = "0xzz"
+
+	header, err := convertHeader(wire)
+	if err != nil {
+		t.Fatalf("failed to convert header: %v", err)
+	}
+	light := &LightBlock{Header: header, TxCount: len(wire.Transactions), rawTxs: wire.Transactions}
+	body, err := light.Body()
+	if err == nil || body != nil {
+		t.Fatalf("want decode error, got body %v, err %v", body, err)
+	}
+	// the decode result is kept, later calls return the same error
+	body, again := light.Body()
+	if again != err || body != nil {
+		t.Fatalf("want cached error %v, got body %v, err %v", err, body, again)
+	}
+}
+
+func TestFetchSlots(t *testing.T) {
+	service := newTestService(t, newTestArchiver())
+	for i := 0; i < MaxConcurrentBundleFetches; i++ {
+		if err := service.acquireFetchSlot(context.Background(), priorityForeground); err != nil {
+			t.Fatalf("failed to acquire slot %d: %v", i, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := service.acquireFetchSlot(ctx, priorityForeground); err != context.DeadlineExceeded {
+		t.Fatalf("want deadline exceeded with all slots taken, got %v", err)
+	}
+	service.releaseFetchSlot()
+	if err := service.acquireFetchSlot(context.Background(), priorityForeground); err != nil {
+		t.Fatalf("failed to acquire released slot: %v", err)
+	}
+}
+
+func TestFetchSlotsPriority(t *testing.T) {
+	service := newTestService(t, newTestArchiver())
+	for i := 0; i < MaxConcurrentBundleFetches; i++ {
+		if err := service.acquireFetchSlot(context.Background(), priorityForeground); err != nil {
+			t.Fatalf("failed to acquire slot %d: %v", i, err)
+		}
+	}
+	granted := make(chan fetchPriority, 2)
+	waitQueued := func(priority fetchPriority) {
+		for service.fetchSlots.queued(priority) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	go func() {
+		service.acquireFetchSlot(context.Background(), priorityBackground)
+		granted <- priorityBackground
+	}()
+	waitQueued(priorityBackground)
+	go func() {
+		service.acquireFetchSlot(context.Background(), priorityForeground)
+		granted <- priorityForeground
+	}()
+	waitQueued(priorityForeground)
+
+	// the foreground waiter arrived last but gets the first freed slot
+	service.releaseFetchSlot()
+	if priority := <-granted; priority != priorityForeground {
+		t.Fatalf("want the freed slot granted to the foreground waiter, got %d", priority)
+	}
+	select {
+	case <-granted:
+		t.Fatal("background waiter granted a slot beyond the cap")
+	case <-time.After(50 * time.Millisecond):
+	}
+	service.releaseFetchSlot()
+	if priority := <-granted; priority != priorityBackground {
+		t.Fatalf("want the next slot granted to the background waiter, got %d", priority)
+	}
+	if n := service.fetchSlots.inUse(); n != MaxConcurrentBundleFetches {
+		t.Errorf("want %d slots in use, got %d", MaxConcurrentBundleFetches, n)
+	}
+}
+
+func TestInflightLimit(t *testing.T) {
+	archiver := newTestArchiver()
+	service := newTestService(t, archiver)
+	if service.maxInflight != DefaultMaxInflightRequests {
+		t.Fatalf("want default limit %d, got %d", DefaultMaxInflightRequests, service.maxInflight)
+	}
+	for i := int64(0); i < service.maxInflight; i++ {
+		if err := service.acquireInflight(); err != nil {
+			t.Fatalf("failed to acquire request %d: %v", i, err)
+		}
+	}
+	if _, _, err := service.GetBlockByNumber(100); err != ErrOverloaded {
+		t.Fatalf("want %v with the limit reached, got %v", ErrOverloaded, err)
+	}
+	if n := archiver.callCount("bundle/name"); n != 0 {
+		t.Errorf("want shed request to skip the archiver, got %d bundle requests", n)
+	}
+	if n := service.inflight.Load(); n != service.maxInflight {
+		t.Errorf("want %d in-flight requests after shedding, got %d", service.maxInflight, n)
+	}
+	service.releaseInflight()
+	if err := service.acquireInflight(); err != nil {
+		t.Fatalf("failed to acquire released request: %v", err)
+	}
+}
+
+// newTestReceipts returns receipts of the given transactions, each with a log
+func newTestReceipts(txs []*types.Transaction) []*types.Receipt {
+	receipts := make([]*types.Receipt, 0, len(txs))
+	for i, tx := range txs {
+		receipt := &types.Receipt{
+			Type:              tx.Type(),
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: uint64(i+1) * 21000,
+			GasUsed:           21000,
+			TxHash:            tx.Hash(),
+			TransactionIndex:  uint(i),
+			Logs: []*types.Log{{
+				Address: *tx.To(),
+				Topics:  []common.Hash{{0x01}, {byte(i)}},
+				Data:    []byte{0xca, 0xfe},
+				Index:   uint(i),
+			}},
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		receipts = append(receipts, receipt)
+	}
+	return receipts
+}
+
+// toWireReceipts encodes receipts the way the block archiver serves them
+func toWireReceipts(header *types.Header, receipts []*types.Receipt) []*Receipt {
+	wire := make([]*Receipt, 0, len(receipts))
+	for _, r := range receipts {
+		receipt := &Receipt{
+			Type:              hexutil.EncodeUint64(uint64(r.Type)),
+			Status:            hexutil.EncodeUint64(r.Status),
+			CumulativeGasUsed: hexutil.EncodeUint64(r.CumulativeGasUsed),
+			LogsBloom:         hexutil.Encode(r.Bloom[:]),
+			TransactionHash:   r.TxHash.Hex(),
+			TransactionIndex:  hexutil.EncodeUint64(uint64(r.TransactionIndex)),
+			GasUsed:           hexutil.EncodeUint64(r.GasUsed),
+			BlockHash:         header.Hash().Hex(),
+			BlockNumber:       hexutil.EncodeBig(header.Number),
+		}
+		for _, l := range r.Logs {
+			entry := Log{Address: l.Address.Hex(), Data: hexutil.Encode(l.Data), LogIndex: hexutil.EncodeUint64(uint64(l.Index))}
+			for _, topic := range l.Topics {
+				entry.Topics = append(entry.Topics, topic.Hex())
+			}
+			receipt.Logs = append(receipt.Logs, entry)
+		}
+		wire = append(wire, receipt)
+	}
+	return wire
+}
+
+func TestGetBlockAndReceipts(t *testing.T) {
+	txs := newTestTransactions(t)
+	receipts := newTestReceipts(txs)
+	header := newTestHeader(100, txs)
+	header.ReceiptHash = types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil))
+	wire := toWireBlock(header, txs)
+
+	archiver := newTestArchiver(wire)
+	archiver.receipts[100] = toWireReceipts(header, receipts)
+	service := newTestService(t, archiver)
+	block, err := convertBlock(wire)
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(block.Block)
+
+	for i := 0; i < 2; i++ {
+		got, gotReceipts, err := service.GetBlockAndReceipts(100)
+		if err != nil {
+			t.Fatalf("failed to get block and receipts: %v", err)
+		}
+		if got.Hash() != header.Hash() {
+			t.Errorf("block hash mismatch, want %s, got %s", header.Hash(), got.Hash())
+		}
+		if len(gotReceipts) != len(receipts) {
+			t.Fatalf("want %d receipts, got %d", len(receipts), len(gotReceipts))
+		}
+		for j, r := range gotReceipts {
+			if r.TxHash != txs[j].Hash() || len(r.Logs) != 1 || r.Logs[0].Data[0] != 0xca {
+				t.Errorf("receipt %d mismatch: %+v", j, r)
+			}
+		}
+	}
+	if n := archiver.callCount("eth_getBlockReceipts"); n != 1 {
+		t.Errorf("want receipts fetched once, got %d requests", n)
+	}
+
+	// receipts not matching the receipts root are rejected
+	archiver.receipts[100][0].CumulativeGasUsed = "0x1"
+	service.receiptCache.Purge()
+	if _, _, err := service.GetBlockAndReceipts(100); err == nil {
+		t.Error("want receipts root mismatch, got nil")
+	}
+}
+
+func TestGetBlockReceiptsByHash(t *testing.T) {
+	txs := newTestTransactions(t)
+	receipts := newTestReceipts(txs)
+	header := newTestHeader(100, txs)
+	header.ReceiptHash = types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil))
+	wire := toWireBlock(header, txs)
+
+	archiver := newTestArchiver(wire)
+	archiver.receipts[100] = toWireReceipts(header, receipts)
+	service := newTestService(t, archiver)
+	// the bundle of the block holds it alone
+	service.checkCompleteness = false
+	serveBundles(service)
+
+	// the client returns the raw receipts of the block by hash
+	raw, err := service.client.GetBlockReceiptsByHash(context.Background(), header.Hash())
+	if err != nil {
+		t.Fatalf("failed to get raw receipts: %v", err)
+	}
+	if len(raw) != len(receipts) {
+		t.Fatalf("want %d raw receipts, got %d", len(receipts), len(raw))
+	}
+	// the service checks them against the receipts root of the block
+	got, err := service.GetBlockReceiptsByHash(header.Hash())
+	if err != nil {
+		t.Fatalf("failed to get receipts: %v", err)
+	}
+	if len(got) != len(receipts) {
+		t.Fatalf("want %d receipts, got %d", len(receipts), len(got))
+	}
+	for i, r := range got {
+		if r.TxHash != txs[i].Hash() || r.Status != receipts[i].Status || r.CumulativeGasUsed != receipts[i].CumulativeGasUsed || r.Bloom != receipts[i].Bloom {
+			t.Errorf("receipt %d mismatch: %+v", i, r)
+		}
+	}
+	if got, err := service.GetBlockReceipts(100); err != nil || len(got) != len(receipts) {
+		t.Errorf("by number: got %d receipts, err %v", len(got), err)
+	}
+	if got, err := service.GetBlockReceiptsByHash(common.Hash{0x01}); err != nil || got != nil {
+		t.Errorf("unknown block: got %v, err %v", got, err)
+	}
+}
+
+func TestGetTransactionsByNumber(t *testing.T) {
+	txs := newTestTransactions(t)
+	// a pre EIP-155 transaction, without replay protection
+	to := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	unprotected, err := types.SignNewTx(testKey, types.HomesteadSigner{}, &types.LegacyTx{Nonce: 3, To: &to, Gas: 21000, GasPrice: big.NewInt(1)})
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	txs = append(txs, unprotected)
+	header := newTestHeader(100, txs)
+	wire := toWireBlock(header, txs)
+
+	archiver := newTestArchiver(wire)
+	service := newTestService(t, archiver)
+	block, err := convertBlock(wire)
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(block.Block)
+
+	got, err := service.GetTransactionsByNumber(100)
+	if err != nil {
+		t.Fatalf("failed to get transactions: %v", err)
+	}
+	if len(got) != len(txs) {
+		t.Fatalf("want %d transactions, got %d", len(txs), len(got))
+	}
+	for i, tx := range got {
+		if tx.Type() != txs[i].Type() {
+			t.Errorf("transaction %d type mismatch, want %d, got %d", i, txs[i].Type(), tx.Type())
+		}
+		if tx.Hash().Hex() != wire.Transactions[i].Hash {
+			t.Errorf("transaction %d hash mismatch, want %s, got %s", i, wire.Transactions[i].Hash, tx.Hash().Hex())
+		}
+		from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		if err != nil || from != testAddr {
+			t.Errorf("transaction %d sender mismatch, want %s, got %s (%v)", i, testAddr, from, err)
+		}
+	}
+
+	// a transaction not matching the hash served by the archiver is rejected
+	wire.Transactions[1].Hash = common.Hash{0x01}.Hex()
+	if _, err := convertBlock(wire); err == nil {
+		t.Error("want transaction hash mismatch, got nil")
+	}
+}
+
+func TestGetBlockWithAncestors(t *testing.T) {
+	blocks := newTestChain(t, 0, 5)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	headers, err := service.GetBlockWithAncestors(4, 3)
+	if err != nil {
+		t.Fatalf("failed to get block with ancestors: %v", err)
+	}
+	if len(headers) != 3 {
+		t.Fatalf("want 3 headers, got %d", len(headers))
+	}
+	for i, header := range headers {
+		if want := blocks[2+i].Hash; header.Hash().Hex() != want {
+			t.Errorf("header %d mismatch, want %s, got %s", i, want, header.Hash().Hex())
+		}
+	}
+	// the headers are now cached
+	if _, err := service.GetBlockWithAncestors(4, 2); err != nil {
+		t.Fatalf("failed to get cached block with ancestors: %v", err)
+	}
+	if n := archiver.callCount("batch"); n != 1 {
+		t.Errorf("want cached headers reused, got %d batches", n)
+	}
+	// the depth is clamped at the genesis block
+	headers, err = service.GetBlockWithAncestors(1, 10)
+	if err != nil {
+		t.Fatalf("failed to get clamped ancestors: %v", err)
+	}
+	if len(headers) != 2 || headers[0].Number.Uint64() != 0 {
+		t.Errorf("want headers [0, 1], got %d headers", len(headers))
+	}
+	if _, err := service.GetBlockWithAncestors(4, 0); err == nil {
+		t.Error("want error for a zero depth, got nil")
+	}
+}
+
+func TestBundleNameFor(t *testing.T) {
+	archiver := newTestArchiver()
+	service := newTestService(t, archiver)
+
+	for _, number := range []uint64{105, 100, 109} {
+		name, err := service.BundleNameFor(number)
+		if err != nil {
+			t.Fatalf("block %d: failed to get bundle name: %v", number, err)
+		}
+		if name != "blocks_s100_e109" {
+			t.Errorf("block %d: want bundle blocks_s100_e109, got %s", number, name)
+		}
+	}
+	bundle, err := service.BundleFor(110)
+	if err != nil {
+		t.Fatalf("failed to get bundle: %v", err)
+	}
+	if bundle.Name != "blocks_s110_e119" || bundle.From != 110 || bundle.To != 119 {
+		t.Errorf("bundle mismatch, got %+v", bundle)
+	}
+	// only the first lookup of each bundle reaches the archiver
+	if n := archiver.callCount("bundle/name"); n != 2 {
+		t.Errorf("want 2 bundle name lookups, got %d", n)
+	}
+}
+
+func TestLag(t *testing.T) {
+	service := newTestService(t, newTestArchiver(newTestChain(t, 100, 3)...))
+	tests := []struct {
+		reference uint64
+		blocks    uint64
+	}{
+		{reference: 110, blocks: 8},
+		{reference: 102, blocks: 0},
+		{reference: 90, blocks: 0},
+	}
+	for _, tt := range tests {
+		blocks, duration, err := service.Lag(tt.reference)
+		if err != nil {
+			t.Fatalf("reference %d: failed to get lag: %v", tt.reference, err)
+		}
+		if blocks != tt.blocks || duration != time.Duration(tt.blocks)*BlockInterval {
+			t.Errorf("reference %d: want lag %d blocks %v, got %d blocks %v", tt.reference,
+				tt.blocks, time.Duration(tt.blocks)*BlockInterval, blocks, duration)
+		}
+	}
+}
+
+func TestCompareBlock(t *testing.T) {
+	blocks := newTestChain(t, 100, 1)
+	archiverA := newTestArchiver(blocks...)
+	serverA := httptest.NewServer(archiverA)
+	defer serverA.Close()
+
+	diverged := *blocks[0]
+	diverged.GasUsed = "0x1"
+	serverB := httptest.NewServer(newTestArchiver(&diverged))
+	defer serverB.Close()
+
+	service := newTestService(t, newTestArchiver())
+	diff, err := service.CompareBlock(100, serverA.URL, serverA.URL)
+	if err != nil {
+		t.Fatalf("failed to compare block: %v", err)
+	}
+	if !diff.Equal() {
+		t.Errorf("want equal blocks from the same archiver, got %+v", diff.Fields)
+	}
+	if n := archiverA.callCount("eth_getBlockByNumber"); n != 2 {
+		t.Errorf("want 2 requests routed to the override host, got %d", n)
+	}
+	diff, err = service.CompareBlock(100, serverA.URL, serverB.URL)
+	if err != nil {
+		t.Fatalf("failed to compare block: %v", err)
+	}
+	if diff.Equal() || diff.HashA == diff.HashB {
+		t.Fatal("want diverged blocks, got equal")
+	}
+	found := false
+	for _, field := range diff.Fields {
+		if field.Field == "gasUsed" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("want gasUsed difference, got %+v", diff.Fields)
+	}
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	service := newTestService(t, newTestArchiver())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- service.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("close failed: %v", err)
+		}
+	}
+	select {
+	case <-service.quit:
+	default:
+		t.Error("service not stopped after close")
+	}
+	if err := service.client.Close(); err != nil {
+		t.Errorf("closing the closed client failed: %v", err)
+	}
+}
+
+func TestCloseInflightFetch(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	started := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the requests hang until they are cancelled
+		io.Copy(io.Discard, r.Body)
+		once.Do(func() { close(started) })
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	service := newTestService(t, archiver)
+	service.client.blockArchiverHost = server.URL
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := service.GetBlockByNumber(105)
+		errs <- err
+	}()
+	<-started
+	if err := service.Close(); err != nil {
+		t.Fatalf("failed to close with an in-flight fetch: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("want error from the cancelled fetch, got nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in-flight fetch not cancelled by close")
+	}
+	if service.hashCache.Len() != 0 || service.bodyCache.Len() != 0 {
+		t.Error("cancelled fetch populated the caches")
+	}
+	if _, _, err := service.GetBlockByNumber(105); !errors.Is(err, ErrClosed) {
+		t.Errorf("want ErrClosed after close, got %v", err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	service := newTestService(t, newTestArchiver())
+	service.closeTimeout = 10 * time.Millisecond
+	// a fetch never returning
+	if err := service.beginFetch(); err != nil {
+		t.Fatalf("failed to begin fetch: %v", err)
+	}
+	err := service.Close()
+	if err == nil {
+		t.Fatal("want error with a pending fetch past the timeout, got nil")
+	}
+	if !strings.Contains(err.Error(), "1 block archiver requests still pending") {
+		t.Errorf("error misses the pending fetches: %v", err)
+	}
+}
+
+func TestClosedEntryPoints(t *testing.T) {
+	blocks := newTestChain(t, 100, 10)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+	if err := service.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	calls := []func() error{
+		func() error { _, _, err := service.GetBlockByHash(common.HexToHash(blocks[5].Hash)); return err },
+		func() error { _, err := service.GetLatestBlock(); return err },
+		func() error { _, _, err := service.GetBlockAndReceipts(105); return err },
+		func() error { _, err := service.GetHeadersByRange(100, 109); return err },
+		func() error { _, err := service.GetLightBlockByNumber(105); return err },
+		func() error { _, err := service.GetGenesis(); return err },
+		func() error { _, err := service.BundleFor(105); return err },
+		func() error { return service.VerifySegment(context.Background(), 100, 109, nil) },
+	}
+	for i, call := range calls {
+		if err := call(); !errors.Is(err, ErrClosed) {
+			t.Errorf("call %d: want ErrClosed after close, got %v", i, err)
+		}
+	}
+	if n := archiver.callCount("eth_getBlockByNumber") + archiver.callCount("eth_getBlockByHash"); n != 0 {
+		t.Errorf("want no request after close, got %d", n)
+	}
+}
+
+func TestNotArchived(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	service := newTestService(t, archiver)
+
+	for _, number := range []uint64{99, 110} {
+		_, _, err := service.GetBlockByNumber(number)
+		var notArchived *NotArchivedError
+		if !errors.As(err, &notArchived) || !errors.Is(err, ErrNotArchived) {
+			t.Fatalf("block %d: want %v, got %v", number, ErrNotArchived, err)
+		}
+		if notArchived.Number != number || notArchived.Earliest != 100 || notArchived.Latest != 109 {
+			t.Errorf("block %d: unexpected window %+v", number, notArchived)
+		}
+	}
+	if n := archiver.callCount("bundle/name"); n != 0 {
+		t.Errorf("want no bundle request out of the window, got %d", n)
+	}
+	// the window is cached
+	if n := archiver.callCount("eth_getBlockByNumber"); n != 2 {
+		t.Errorf("want the window fetched once, got %d requests", n)
+	}
+
+	// a block above a window older than a block interval is let through while the window is
+	// refreshed in the background
+	archiver.blocks[110] = newTestChain(t, 110, 1)[0]
+	service.coverage.updated = service.clock.Now().Add(-2 * BlockInterval)
+	if err := service.checkCoverage(110); err != nil {
+		t.Errorf("want block 110 let through a stale window, got %v", err)
+	}
+	service.coverage.refresh.Do("coverage", func() (interface{}, error) { return nil, nil })
+	if err := service.checkCoverage(111); !errors.Is(err, ErrNotArchived) {
+		t.Errorf("want block 111 out of the refreshed window, got %v", err)
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != 4 {
+		t.Errorf("want the window fetched twice, got %d requests", n)
+	}
+}
+
+func TestCoverageBackoff(t *testing.T) {
+	archiver := newTestArchiver()
+	service := newTestService(t, archiver)
+	clock := new(mclock.Simulated)
+	service.clock = clock
+
+	// the check is skipped while the window can't be fetched
+	if err := service.checkCoverage(100); err != nil {
+		t.Fatalf("want the check skipped without a window, got %v", err)
+	}
+	for i, step := range []struct {
+		advance time.Duration
+		fetches int
+	}{
+		{0, 1},                 // backing off after the first failure
+		{BlockInterval, 2},     // retried after a block interval
+		{BlockInterval, 2},     // the backoff doubles
+		{BlockInterval, 3},     // retried after two block intervals
+		{3 * BlockInterval, 3}, // then after four
+		{BlockInterval, 4},
+		{10 * coverageTTL, 5}, // the backoff is capped at coverageTTL
+		{coverageTTL - time.Nanosecond, 5},
+		{time.Nanosecond, 6},
+	} {
+		clock.Run(step.advance)
+		if err := service.checkCoverage(100); err != nil {
+			t.Fatalf("step %d: want the check skipped without a window, got %v", i, err)
+		}
+		if n := archiver.callCount("eth_getBlockByNumber"); n != step.fetches {
+			t.Errorf("step %d: want %d coverage fetches, got %d", i, step.fetches, n)
+		}
+	}
+}
+
+func TestTrustedCheckpoints(t *testing.T) {
+	blocks := newTestChain(t, 100, 10)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+	cache, err := newDiskCache(t.TempDir(), DiskCacheRLP)
+	if err != nil {
+		t.Fatalf("failed to create disk cache: %v", err)
+	}
+	if err := cache.store("blocks_s100_e109", blocks); err != nil {
+		t.Fatalf("failed to store bundle: %v", err)
+	}
+	service.diskCache = cache
+
+	// matching checkpoints
+	service.checkpoints = map[uint64]common.Hash{
+		103: common.HexToHash(blocks[3].Hash),
+		105: common.HexToHash(blocks[5].Hash),
+	}
+	if _, _, err := service.GetBlockByNumber(105); err != nil {
+		t.Fatalf("failed to get block matching its checkpoint: %v", err)
+	}
+	if _, err := service.GetHeadersByRange(100, 109); err != nil {
+		t.Fatalf("failed to get headers matching their checkpoints: %v", err)
+	}
+
+	// a mismatching checkpoint fails the fetch
+	service.hashCache.Purge()
+	service.headerCache.Purge()
+	service.bodyCache.Purge()
+	service.checkpoints[103] = common.Hash{0x01}
+	if _, _, err := service.GetBlockByNumber(105); err == nil {
+		t.Error("want checkpoint mismatch from the bundle fetch, got nil")
+	}
+	if service.hashCache.Contains(103) {
+		t.Error("block mismatching its checkpoint was cached")
+	}
+	if _, err := service.GetBlockHashByNumber(103); err == nil {
+		t.Error("want checkpoint mismatch from the header fetch, got nil")
+	}
+	if _, err := service.GetHeadersByRange(100, 109); err == nil {
+		t.Error("want checkpoint mismatch from the range fetch, got nil")
+	}
+}
+
+func TestGetGenesis(t *testing.T) {
+	genesis := &types.Header{
+		UncleHash:   types.EmptyUncleHash,
+		Root:        common.HexToHash("0x919fcc7ad870b53db0aa76eb588da06bacb6d230195100699fc928511003b422"),
+		TxHash:      types.EmptyTxsHash,
+		ReceiptHash: types.EmptyReceiptsHash,
+		Difficulty:  big.NewInt(1),
+		Number:      big.NewInt(0),
+		GasLimit:    40000000,
+		Time:        1587390414,
+		Extra:       make([]byte, 32+20*21+65),
+	}
+	wire := toWireBlock(genesis, nil)
+	wire.TotalDifficulty = ""
+	archiver := newTestArchiver(wire)
+	service := newTestService(t, archiver)
+
+	for i := 0; i < 3; i++ {
+		header, err := service.GetGenesis()
+		if err != nil {
+			t.Fatalf("failed to get genesis: %v", err)
+		}
+		if header.Hash() != genesis.Hash() {
+			t.Fatalf("genesis hash mismatch, want %s, got %s", genesis.Hash(), header.Hash())
+		}
+	}
+	// the genesis stays available even once evicted from the caches
+	service.headerCache.Purge()
+	service.hashCache.Purge()
+	if _, err := service.GetGenesis(); err != nil {
+		t.Fatalf("failed to get genesis after purge: %v", err)
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != 1 {
+		t.Errorf("want genesis fetched once, got %d requests", n)
+	}
+}
+
+func TestGetHeadersByRange(t *testing.T) {
+	blocks := newTestChain(t, 100, headerBatchSize+20)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	from, to := uint64(100), uint64(100+headerBatchSize+19)
+	headers, err := service.GetHeadersByRange(from, to)
+	if err != nil {
+		t.Fatalf("failed to get headers: %v", err)
+	}
+	if len(headers) != len(blocks) {
+		t.Fatalf("want %d headers, got %d", len(blocks), len(headers))
+	}
+	for i, header := range headers {
+		if header.Hash() != common.HexToHash(blocks[i].Hash) {
+			t.Fatalf("header %d hash mismatch, want %s, got %s", i, blocks[i].Hash, header.Hash())
+		}
+	}
+	if n := archiver.callCount("batch"); n != 2 {
+		t.Errorf("want 2 batches, got %d", n)
+	}
+	for _, params := range archiver.callParams("eth_getBlockByNumber") {
+		if len(params) != 2 || params[1] != "false" {
+			t.Errorf("want header request without transaction details, got params %v", params)
+		}
+	}
+	hash, found := service.hashCache.Get(to)
+	if !found || hash != headers[len(headers)-1].Hash() {
+		t.Errorf("block %d hash not cached", to)
+	}
+	if _, found := service.headerCache.Get(hash); !found {
+		t.Errorf("block %d header not cached", to)
+	}
+	if service.bodyCache.Len() != 0 {
+		t.Errorf("want empty body cache, got %d entries", service.bodyCache.Len())
+	}
+	if _, err := service.GetHeadersByRange(to, to+1); err == nil {
+		t.Error("want error for a range past the archived blocks, got nil")
+	}
+	if _, err := service.GetHeadersByRange(to, from); err == nil {
+		t.Error("want error for an inverted range, got nil")
+	}
+}
+
+func TestGetBlockByHashDuringBundleFetch(t *testing.T) {
+	chain := newTestChain(t, 100, 10)
+	archiver := newTestArchiver(chain...)
+	archiver.bundleDelay = 200 * time.Millisecond
+	service := newTestService(t, archiver)
+	serveBundles(service)
+
+	block, err := convertBlock(chain[5])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, header, err := service.GetBlockByNumber(105)
+		if err == nil && header.Hash() != block.Hash() {
+			err = errors.New("wrong block returned by number")
+		}
+		errc <- err
+	}()
+	for archiver.callCount("bundle/object") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// the request by hash fetches the block alone, not the bundle being fetched by number
+	_, header, err := service.GetBlockByHash(block.Hash())
+	if err != nil {
+		t.Fatalf("failed to get block by hash: %v", err)
+	}
+	if header == nil || header.Hash() != block.Hash() {
+		t.Errorf("wrong block returned by hash")
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to get block by number: %v", err)
+	}
+	if n := archiver.callCount("bundle/object"); n != 1 {
+		t.Errorf("want only the bundle fetched by number, got %d bundle fetches", n)
+	}
+	if n := archiver.callCount("eth_getBlockByHash"); n != 1 {
+		t.Errorf("want a single block request by hash, got %d", n)
+	}
+}
+
+func TestGetBlockByHashCached(t *testing.T) {
+	chain := newTestChain(t, 100, 10)
+	archiver := newTestArchiver(chain...)
+	service := newTestService(t, archiver)
+	serveBundles(service)
+
+	block, err := convertBlock(chain[5])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	// the block fetched by hash is returned without fetching its bundle
+	_, header, err := service.GetBlockByHash(block.Hash())
+	if err != nil {
+		t.Fatalf("failed to get block by hash: %v", err)
+	}
+	if header == nil || header.Hash() != block.Hash() {
+		t.Fatalf("wrong block returned by hash")
+	}
+	if n := archiver.callCount("bundle/object") + archiver.callCount("eth_getBundledBlockByNumber"); n != 0 {
+		t.Errorf("want no bundle fetch, got %d", n)
+	}
+	// and it is cached for the requests by number
+	if _, header, err = service.GetBlockByNumber(105); err != nil || header.Hash() != block.Hash() {
+		t.Fatalf("failed to get the cached block by number: %v", err)
+	}
+	if n := archiver.callCount("eth_getBlockByHash"); n != 1 {
+		t.Errorf("want a single block request, got %d", n)
+	}
+	if n := archiver.callCount("bundle/object"); n != 0 {
+		t.Errorf("want no bundle fetch, got %d", n)
+	}
+}
+
+func TestVerifyHashes(t *testing.T) {
+	chain := newTestChain(t, 100, 10)
+	// the archiver serves a tampered block under its original hash
+	chain[5].GasUsed = "0x1"
+	archiver := newTestArchiver(chain...)
+	service := newTestService(t, archiver)
+	serveBundles(service)
+
+	// without the check the tampered block is accepted
+	if _, _, err := service.GetBlockByNumber(105); err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	service.hashCache.Purge()
+	service.verifyHashes = true
+	if _, _, err := service.GetBlockByNumber(105); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Fatalf("want a hash mismatch, got %v", err)
+	}
+}
+
+func TestEmptyBundle(t *testing.T) {
+	// the archiver names a bundle for every block but holds none of them
+	archiver := newTestArchiver()
+	service := newTestService(t, archiver)
+	service.emptyBundleRetries = 1
+	serveBundles(service)
+
+	_, _, err := service.GetBlockByNumber(105)
+	if !errors.Is(err, ErrMalformedBundle) {
+		t.Fatalf("want ErrMalformedBundle, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "blocks_s100_e109") || !strings.Contains(err.Error(), "[100, 109]") {
+		t.Errorf("error misses the bundle name or range: %v", err)
+	}
+	if n := archiver.callCount("bundle/object"); n != 2 {
+		t.Errorf("want the empty bundle fetched twice, got %d", n)
+	}
+}
+
+func TestGetRawTransactionsByNumber(t *testing.T) {
+	chain := newTestChain(t, 100, 10)
+	service := newTestService(t, newTestArchiver(chain...))
+	block, err := convertBlock(chain[5])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(block.Block)
+
+	txs, err := service.GetRawTransactionsByNumber(105)
+	if err != nil {
+		t.Fatalf("failed to get raw transactions: %v", err)
+	}
+	if !reflect.DeepEqual(txs, chain[5].Transactions) {
+		t.Errorf("raw transactions mismatch, want %+v, got %+v", chain[5].Transactions, txs)
+	}
+}
+
+func TestGetBlockTimeout(t *testing.T) {
+	service := newTestService(t, newTestArchiver(newTestChain(t, 100, 10)...))
+	clock := new(mclock.Simulated)
+	service.clock = clock
+	service.getBlockTimeout = 2 * time.Second
+
+	// another request is fetching the bundle of the block and never completes
+	service.requestLock.AddRange(100, 109)
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := service.GetBlockByNumber(105)
+		errc <- err
+	}()
+	clock.WaitForTimers(1)
+	clock.Run(time.Second)
+	select {
+	case err := <-errc:
+		t.Fatalf("wait ended before the configured timeout: %v", err)
+	default:
+	}
+	clock.Run(time.Second)
+	if err := <-errc; !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("want %v once the wait timed out, got %v", ErrBlockNotFound, err)
+	}
+}
+
+func TestGetBlockByNumberContextCancel(t *testing.T) {
+	service := newTestService(t, newTestArchiver(newTestChain(t, 100, 10)...))
+
+	// another request is fetching the bundle of the block and never completes
+	service.requestLock.AddRange(100, 109)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := service.GetBlockByNumberContext(ctx, 105)
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait not abandoned after the context was cancelled")
+	}
+}
+
+func TestNotFoundTTL(t *testing.T) {
+	// the archiver names a bundle for every block but holds none of them
+	archiver := newTestArchiver()
+	service := newTestService(t, archiver)
+	serveBundles(service)
+	clock := new(mclock.Simulated)
+	service.clock = clock
+	service.missing = newMissingBlocks(time.Second, clock)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := service.GetBlockByNumber(105); !errors.Is(err, ErrMalformedBundle) {
+			t.Fatalf("want ErrMalformedBundle, got %v", err)
+		}
+	}
+	if n := archiver.callCount("bundle/name"); n != 1 {
+		t.Errorf("want the missing block requested once within the ttl, got %d", n)
+	}
+	clock.Run(time.Second + 1)
+	if _, _, err := service.GetBlockByNumber(105); !errors.Is(err, ErrMalformedBundle) {
+		t.Fatalf("want ErrMalformedBundle, got %v", err)
+	}
+	if n := archiver.callCount("bundle/name"); n != 2 {
+		t.Errorf("want the missing block requested again past the ttl, got %d", n)
+	}
+
+	// the block landing in the cache drops the negative entry
+	block, err := convertBlock(newTestChain(t, 105, 1)[0])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(block.Block)
+	if err := service.missing.get(105); err != nil {
+		t.Error("cached block still reported missing")
+	}
+	if _, header, err := service.GetBlockByNumber(105); err != nil || header.Hash() != block.Hash() {
+		t.Errorf("failed to get the cached block: %v", err)
+	}
+}
+
+func TestBundleResults(t *testing.T) {
+	chain := newTestChain(t, 100, 10)
+	archiver := newTestArchiver(chain...)
+	archiver.bundleDelay = 100 * time.Millisecond
+	service := newTestService(t, archiver)
+	service.results = newBundleResults(time.Minute, service.clock)
+	serveBundles(service)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(chain))
+	for i := range chain {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = service.GetBlockByNumber(100 + uint64(i))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("block %d: %v", 100+i, err)
+		}
+	}
+	// a straggler missing the caches after the fetch completed reuses the fetched bundle
+	service.hashCache.Purge()
+	if _, header, err := service.GetBlockByNumber(107); err != nil || header.Number.Uint64() != 107 {
+		t.Fatalf("failed to get straggler block: %v", err)
+	}
+	if n := archiver.callCount("bundle/object"); n != 1 {
+		t.Errorf("want a single bundle fetch, got %d", n)
+	}
+}
+
+func TestIncompleteBundle(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 10)...)
+	delete(archiver.blocks, 104)
+
+	service := newTestService(t, archiver)
+	serveBundles(service)
+	_, _, err := service.GetBlockByNumber(105)
+	if !errors.Is(err, ErrMalformedBundle) {
+		t.Fatalf("want ErrMalformedBundle, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "misses blocks [104]") {
+		t.Errorf("error doesn't list the missing block: %v", err)
+	}
+	if _, found := service.hashCache.Get(105); found {
+		t.Error("incomplete bundle was cached")
+	}
+
+	// the partial bundle is accepted with the check disabled
+	service = newTestService(t, archiver)
+	service.checkCompleteness = false
+	serveBundles(service)
+	if _, _, err := service.GetBlockByNumber(105); err != nil {
+		t.Fatalf("failed to get block without the completeness check: %v", err)
+	}
+}
+
+func TestLatestBlockTTL(t *testing.T) {
+	archiver := newTestArchiver(newTestChain(t, 100, 3)...)
+	service := newTestService(t, archiver)
+	clock := new(mclock.Simulated)
+	service.clock = clock
+	service.latestTTL = 3 * time.Second
+
+	// the latest block is reused up to the end of the ttl
+	for _, elapse := range []time.Duration{0, time.Second, 2*time.Second - 1} {
+		clock.Run(elapse)
+		if _, err := service.GetLatestBlock(); err != nil {
+			t.Fatalf("failed to get latest block: %v", err)
+		}
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != 1 {
+		t.Errorf("want a single request within the ttl, got %d", n)
+	}
+	clock.Run(1)
+	if _, err := service.GetLatestBlock(); err != nil {
+		t.Fatalf("failed to get latest block: %v", err)
+	}
+	if n := archiver.callCount("eth_getBlockByNumber"); n != 2 {
+		t.Errorf("want the latest block refreshed after the ttl, got %d requests", n)
+	}
+}
+
+func TestGetTransactionByBlockNumberAndIndex(t *testing.T) {
+	chain := newTestChain(t, 100, 2)
+	archiver := newTestArchiver(chain...)
+	service := newTestService(t, archiver)
+	block, err := convertBlock(chain[0])
+	if err != nil {
+		t.Fatalf("failed to convert block: %v", err)
+	}
+	service.cacheBlock(block.Block)
+
+	for _, number := range []uint64{100, 101} {
+		for i, want := range chain[number-100].Transactions {
+			tx, err := service.GetTransactionByBlockNumberAndIndex(number, uint64(i))
+			if err != nil {
+				t.Fatalf("block %d transaction %d: %v", number, i, err)
+			}
+			if tx.Hash().Hex() != want.Hash {
+				t.Errorf("block %d transaction %d hash mismatch, want %s, got %s", number, i, want.Hash, tx.Hash().Hex())
+			}
+		}
+		var notFound *TransactionNotFoundError
+		_, err := service.GetTransactionByBlockNumberAndIndex(number, uint64(len(chain[0].Transactions)))
+		if !errors.As(err, &notFound) || !errors.Is(err, ethereum.NotFound) {
+			t.Errorf("block %d: want a transaction not found error out of range, got %v", number, err)
+		}
+	}
+	// only the uncached block reaches the archiver
+	if n := archiver.callCount("eth_getTransactionByBlockNumberAndIndex"); n != len(chain[1].Transactions)+1 {
+		t.Errorf("want %d archiver requests, got %d", len(chain[1].Transactions)+1, n)
+	}
+}
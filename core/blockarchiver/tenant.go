@@ -0,0 +1,58 @@
+package blockarchiver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// otherTenant is the tag recorded for requests tagged with a tenant missing from the allowlist,
+// it keeps the number of metrics bounded whatever the callers put in their contexts
+const otherTenant = "other"
+
+// tenantKey is the context key of the tenant a request is accounted to
+type tenantKey struct{}
+
+// WithTenant returns a context which accounts the requests made with it to the given tenant in
+// the logs and metrics of the client
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// WithTenants sets the allowlist of tenant tags, requests tagged with any other tenant are
+// accounted to "other"
+func WithTenants(tenants ...string) Option {
+	return func(c *Client) {
+		c.tenants = make(map[string]struct{}, len(tenants))
+		for _, tenant := range tenants {
+			c.tenants[tenant] = struct{}{}
+		}
+	}
+}
+
+// tenantOf returns the tenant a request made with ctx is accounted to, empty if it isn't tagged
+func (c *Client) tenantOf(ctx context.Context) string {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok || tenant == "" {
+		return ""
+	}
+	if _, allowed := c.tenants[tenant]; !allowed {
+		return otherTenant
+	}
+	return tenant
+}
+
+// recordTenantRequest accounts a finished request to its tenant
+func recordTenantRequest(tenant, method string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	log.Debug("block archiver request", "tenant", tenant, "method", method, "elapsed", elapsed, "err", err)
+
+	prefix := fmt.Sprintf("blockarchiver/tenant/%s/", tenant)
+	metrics.GetOrRegisterTimer(prefix+"requests", nil).Update(elapsed)
+	if err != nil {
+		metrics.GetOrRegisterCounter(prefix+"errors", nil).Inc(1)
+	}
+}
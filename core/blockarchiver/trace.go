@@ -0,0 +1,53 @@
+package blockarchiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// methodNotFound is the JSON-RPC error code of an unknown method
+const methodNotFound = -32601
+
+// ErrNotSupported is returned when the block archiver doesn't serve the requested method
+var ErrNotSupported = errors.New("not supported by the block archiver")
+
+// TraceConfig is passed through to debug_traceBlockByNumber
+type TraceConfig struct {
+	Tracer       string          `json:"tracer,omitempty"`
+	TracerConfig json.RawMessage `json:"tracerConfig,omitempty"`
+	Timeout      string          `json:"timeout,omitempty"`
+}
+
+// traceResponse represents a response from the debug_traceBlockByNumber RPC call
+type traceResponse struct {
+	ID      int64           `json:"id,omitempty"`
+	Error   *JsonError      `json:"error,omitempty"`
+	Jsonrpc string          `json:"jsonrpc,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+// TraceBlockByNumber returns the execution traces of the block by number as returned by the
+// block archiver, the format depends on the tracer. The response is bounded by the client
+// response size limit, ErrNotSupported is returned by archivers without the debug namespace.
+func (c *Client) TraceBlockByNumber(ctx context.Context, number uint64, config TraceConfig) (json.RawMessage, error) {
+	ctx, done := c.methodContext(ctx, "TraceBlockByNumber")
+	defer done()
+	payload := preparePayload(c.methods.TraceBlockByNumber, []interface{}{Int64ToHex(int64(number)), config})
+	body, err := c.postRequest(withBlock(ctx, number), payload)
+	if err != nil {
+		return nil, err
+	}
+	var resp traceResponse
+	if err := unmarshalJSON(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		if resp.Error.Code == methodNotFound {
+			return nil, fmt.Errorf("%s: %w", c.methods.TraceBlockByNumber, ErrNotSupported)
+		}
+		return nil, resp.Error
+	}
+	return resp.Result, nil
+}
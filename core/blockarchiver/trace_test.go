@@ -0,0 +1,57 @@
+package blockarchiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceBlockByNumber(t *testing.T) {
+	trace := `[{"txHash":"0x01","result":{"type":"CALL","gas":"0x5208"}}]`
+	received := make(chan []interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req testRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		received <- req.Params
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + trace + `}`))
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	config := TraceConfig{Tracer: "callTracer", TracerConfig: json.RawMessage(`{"onlyTopCall":true}`)}
+	result, err := client.TraceBlockByNumber(context.Background(), 100, config)
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	if string(result) != trace {
+		t.Errorf("trace mismatch, want %s, got %s", trace, result)
+	}
+	params := <-received
+	if len(params) != 2 || params[0] != "0x64" {
+		t.Fatalf("unexpected params %v", params)
+	}
+	passed, _ := params[1].(map[string]interface{})
+	if passed["tracer"] != "callTracer" || passed["tracerConfig"].(map[string]interface{})["onlyTopCall"] != true {
+		t.Errorf("tracer config not passed through, got %v", params[1])
+	}
+}
+
+func TestTraceBlockByNumberNotSupported(t *testing.T) {
+	server := httptest.NewServer(newTestArchiver())
+	defer server.Close()
+
+	client, err := New(server.URL, server.URL, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.TraceBlockByNumber(context.Background(), 100, TraceConfig{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %v, got %v", ErrNotSupported, err)
+	}
+}
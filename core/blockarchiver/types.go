@@ -1,11 +1,16 @@
 package blockarchiver
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -16,10 +21,16 @@ type JsonError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Error implements error, the JSON-RPC errors of the archiver are returned as is so callers can
+// switch on their code with errors.As
+func (e *JsonError) Error() string {
+	return fmt.Sprintf("archiver rpc error %d: %s", e.Code, e.Message)
+}
+
 // Block represents a block in the Ethereum blockchain
 type Block struct {
 	WithdrawalsRoot  string        `json:"withdrawalsRoot"`
-	Withdrawals      []string      `json:"withdrawals"`
+	Withdrawals      []Withdrawal  `json:"withdrawals"`
 	Hash             string        `json:"hash"`
 	ParentHash       string        `json:"parentHash"`
 	Sha3Uncles       string        `json:"sha3Uncles"`
@@ -46,6 +57,21 @@ type Block struct {
 	ParentBeaconRoot string        `json:"parentBeaconBlockRoot"`
 }
 
+// BlockWithTxHashes represents a block returned without transaction details, the transactions
+// are only listed by their hashes
+type BlockWithTxHashes struct {
+	Block
+	Transactions []string `json:"transactions"`
+}
+
+// GetChainIDResponse represents a response from the chainId RPC call
+type GetChainIDResponse struct {
+	ID      int64      `json:"id,omitempty"`
+	Error   *JsonError `json:"error,omitempty"`
+	Jsonrpc string     `json:"jsonrpc,omitempty"`
+	Result  string     `json:"result,omitempty"`
+}
+
 // GetBlockResponse represents a response from the getBlock RPC call
 type GetBlockResponse struct {
 	ID      int64      `json:"id,omitempty"`
@@ -54,12 +80,47 @@ type GetBlockResponse struct {
 	Result  *Block     `json:"result,omitempty"`
 }
 
+// GetTransactionResponse represents a response from the getTransactionByHash RPC call
+type GetTransactionResponse struct {
+	ID      int64        `json:"id,omitempty"`
+	Error   *JsonError   `json:"error,omitempty"`
+	Jsonrpc string       `json:"jsonrpc,omitempty"`
+	Result  *Transaction `json:"result,omitempty"`
+}
+
+// GetBlockWithTxHashesResponse represents a response from the getBlock RPC call with the
+// transaction details disabled
+type GetBlockWithTxHashesResponse struct {
+	ID      int64              `json:"id,omitempty"`
+	Error   *JsonError         `json:"error,omitempty"`
+	Jsonrpc string             `json:"jsonrpc,omitempty"`
+	Result  *BlockWithTxHashes `json:"result,omitempty"`
+}
+
+// GetTransactionReceiptResponse represents a response from the getTransactionReceipt RPC call
+type GetTransactionReceiptResponse struct {
+	ID      int64      `json:"id,omitempty"`
+	Error   *JsonError `json:"error,omitempty"`
+	Jsonrpc string     `json:"jsonrpc,omitempty"`
+	Result  *Receipt   `json:"result,omitempty"`
+}
+
+// GetBlockReceiptsResponse represents a response from the eth_getBlockReceipts RPC call
+type GetBlockReceiptsResponse struct {
+	ID      int64      `json:"id,omitempty"`
+	Error   *JsonError `json:"error,omitempty"`
+	Jsonrpc string     `json:"jsonrpc,omitempty"`
+	Result  []*Receipt `json:"result,omitempty"`
+}
+
 // GetBlocksResponse represents a response from the getBlocks RPC call
 type GetBlocksResponse struct {
 	ID      int64      `json:"id,omitempty"`
 	Error   *JsonError `json:"error,omitempty"`
 	Jsonrpc string     `json:"jsonrpc,omitempty"`
 	Result  []*Block   `json:"result,omitempty"`
+	// NextPageToken is set by archivers paginating large bundles, it is passed back to fetch the next page
+	NextPageToken string `json:"nextPageToken,omitempty"`
 }
 
 // GetBundleNameResponse represents a response from the getBundleName RPC call
@@ -67,6 +128,40 @@ type GetBundleNameResponse struct {
 	Data string `json:"data"`
 }
 
+// BundleInfo describes a bundle of blocks stored by the block archiver
+type BundleInfo struct {
+	Name string
+	From uint64
+	To   uint64
+}
+
+// NewBundleInfo parses the block range out of a bundle name
+func NewBundleInfo(name string) (*BundleInfo, error) {
+	from, to, err := ParseBundleName(name)
+	if err != nil {
+		return nil, err
+	}
+	return &BundleInfo{Name: name, From: from, To: to}, nil
+}
+
+// BundleMetadata describes a bundle without its blocks. BlockCount and Size are zero when the
+// block archiver doesn't report them.
+type BundleMetadata struct {
+	BundleInfo
+	BlockCount uint64
+	// Size is the size in bytes of the bundle object
+	Size uint64
+}
+
+// GetBundleMetadataResponse represents a response from the bundle metadata REST endpoint
+type GetBundleMetadataResponse struct {
+	Data *struct {
+		Name       string `json:"name"`
+		BlockCount uint64 `json:"blockCount"`
+		Size       uint64 `json:"size"`
+	} `json:"data"`
+}
+
 // Transaction represents a transaction in the Ethereum blockchain
 type Transaction struct {
 	BlockHash            string        `json:"blockHash"`
@@ -94,6 +189,111 @@ type Transaction struct {
 	BlobVersionedHashes  []string      `json:"blobVersionedHashes"`
 }
 
+// Withdrawal represents a validator withdrawal included in a block
+type Withdrawal struct {
+	Index          string `json:"index"`
+	ValidatorIndex string `json:"validatorIndex"`
+	Address        string `json:"address"`
+	Amount         string `json:"amount"`
+}
+
+// Receipt represents a transaction receipt as served by eth_getBlockReceipts
+type Receipt struct {
+	Type              string `json:"type"`
+	Root              string `json:"root"`
+	Status            string `json:"status"`
+	CumulativeGasUsed string `json:"cumulativeGasUsed"`
+	LogsBloom         string `json:"logsBloom"`
+	Logs              []Log  `json:"logs"`
+	TransactionHash   string `json:"transactionHash"`
+	TransactionIndex  string `json:"transactionIndex"`
+	ContractAddress   string `json:"contractAddress"`
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	BlockHash         string `json:"blockHash"`
+	BlockNumber       string `json:"blockNumber"`
+}
+
+// Log represents a log emitted by a transaction
+type Log struct {
+	Address  string   `json:"address"`
+	Topics   []string `json:"topics"`
+	Data     string   `json:"data"`
+	LogIndex string   `json:"logIndex"`
+	// the position of the log in the chain, only set for the logs returned by eth_getLogs as the
+	// receipts carry it for their logs
+	BlockNumber      string `json:"blockNumber,omitempty"`
+	BlockHash        string `json:"blockHash,omitempty"`
+	TransactionHash  string `json:"transactionHash,omitempty"`
+	TransactionIndex string `json:"transactionIndex,omitempty"`
+	Removed          bool   `json:"removed,omitempty"`
+}
+
+// LogFilter selects the logs returned by GetLogs, an empty Addresses matches any address and the
+// topics match by position, an empty position matching any topic
+type LogFilter struct {
+	FromBlock uint64
+	ToBlock   uint64
+	Addresses []common.Address
+	Topics    [][]common.Hash
+}
+
+// toArg returns the filter as the argument of eth_getLogs
+func (f LogFilter) toArg() map[string]interface{} {
+	arg := map[string]interface{}{
+		"fromBlock": Int64ToHex(int64(f.FromBlock)),
+		"toBlock":   Int64ToHex(int64(f.ToBlock)),
+	}
+	if len(f.Addresses) > 0 {
+		arg["address"] = f.Addresses
+	}
+	if len(f.Topics) > 0 {
+		topics := make([]interface{}, len(f.Topics))
+		for i, position := range f.Topics {
+			if len(position) > 0 {
+				topics[i] = position
+			}
+		}
+		arg["topics"] = topics
+	}
+	return arg
+}
+
+// GetLogsResponse represents a response from the getLogs RPC call
+type GetLogsResponse struct {
+	ID      int64      `json:"id,omitempty"`
+	Error   *JsonError `json:"error,omitempty"`
+	Jsonrpc string     `json:"jsonrpc,omitempty"`
+	Result  []*Log     `json:"result,omitempty"`
+}
+
+// BlockTag names a block relative to the archiver's view of the chain, passed as is to
+// eth_getBlockByNumber in place of a block number
+type BlockTag string
+
+const (
+	BlockTagEarliest BlockTag = "earliest"
+	BlockTagLatest   BlockTag = "latest"
+	BlockTagPending  BlockTag = "pending"
+	BlockTagSafe     BlockTag = "safe"
+	// BlockTagFinalized is the latest block finalized by fast finality, it won't be reorged
+	BlockTagFinalized BlockTag = "finalized"
+)
+
+// blockTags are the tags accepted by GetBlockByTag
+var blockTags = map[BlockTag]struct{}{
+	BlockTagEarliest: {}, BlockTagLatest: {}, BlockTagPending: {}, BlockTagSafe: {}, BlockTagFinalized: {},
+}
+
+// ParseBlockTag returns the block tag named by s, rejecting the unknown tags
+func ParseBlockTag(s string) (BlockTag, error) {
+	tag := BlockTag(s)
+	if _, ok := blockTags[tag]; !ok {
+		return "", fmt.Errorf("unknown block tag %q, want one of earliest, latest, pending, safe or finalized", s)
+	}
+	return tag, nil
+}
+
 // AccessTuple represents a tuple of an address and a list of storage keys
 type AccessTuple struct {
 	Address     string
@@ -103,7 +303,44 @@ type AccessTuple struct {
 // GeneralBlock represents a block in the Ethereum blockchain
 type GeneralBlock struct {
 	*types.Block
-	TotalDifficulty *big.Int `json:"totalDifficulty"` // Total difficulty in the canonical chain up to and including this block.
+	TotalDifficulty *big.Int `json:"totalDifficulty"` // Total difficulty in the canonical chain up to and including this block, nil if not reported by the archiver.
+}
+
+// LightBlock is a lightweight view of a block which carries the header and the number of
+// transactions, the transactions are only decoded when the body is requested.
+type LightBlock struct {
+	Header  *types.Header
+	TxCount int
+
+	rawTxs         []Transaction
+	rawWithdrawals []Withdrawal
+	body           *types.Body
+	err            error
+	decoded        sync.Once
+}
+
+// Body returns the body of the block. For a block built from the archiver response, the first
+// call triggers the full decoding of the transactions, the result is kept for later calls.
+func (b *LightBlock) Body() (*types.Body, error) {
+	b.decoded.Do(func() {
+		if b.body != nil {
+			return
+		}
+		txs, err := convertTransactions(b.rawTxs)
+		if err != nil {
+			b.err = err
+			return
+		}
+		withdrawals, err := convertWithdrawals(b.Header, b.rawWithdrawals)
+		if err != nil {
+			b.err = err
+			return
+		}
+		b.body = &types.Body{Transactions: txs, Uncles: make([]*types.Header, 0), Withdrawals: withdrawals}
+		b.rawTxs = nil
+		b.rawWithdrawals = nil
+	})
+	return b.body, b.err
 }
 
 // Range represents a range of Block numbers
@@ -116,45 +353,72 @@ type Range struct {
 
 // RequestLock is a lock for making sure we don't fetch the same bundle concurrently
 type RequestLock struct {
-	// TODO
-	// there is tradeoff between using a Map or List of ranges, in this case, the lookup needs to be populated every
-	// time a new range is added, but the lookup is faster. If we use a list, we need to iterate over the list to check
-	// if the number is within any of the ranges, but we don't need to populate the lookup every time a new range is added.
-	rangeMap  map[uint64]*Range
-	lookupMap map[uint64]*Range
-	mu        sync.RWMutex
+	// ranges are the ranges being fetched sorted by their first number, they don't overlap as
+	// they are bundle ranges so a number is looked up with a binary search
+	ranges []*Range
+	mu     sync.RWMutex
 }
 
 // NewRequestLock creates a new RequestLock
 func NewRequestLock() *RequestLock {
-	return &RequestLock{
-		rangeMap:  make(map[uint64]*Range),
-		lookupMap: make(map[uint64]*Range),
+	return &RequestLock{}
+}
+
+// search returns the index of the first range starting after num, the range holding num if any
+// is the one right before it
+func (rl *RequestLock) search(num uint64) int {
+	return sort.Search(len(rl.ranges), func(i int) bool { return rl.ranges[i].from > num })
+}
+
+// lookup returns the range holding num, nil if none
+func (rl *RequestLock) lookup(num uint64) *Range {
+	if i := rl.search(num); i > 0 && rl.ranges[i-1].to >= num {
+		return rl.ranges[i-1]
+	}
+	return nil
+}
+
+// insert adds a new range, replacing the range starting at the same number if any
+func (rl *RequestLock) insert(from, to uint64) *Range {
+	newRange := &Range{
+		from: from,
+		to:   to,
+		done: make(chan struct{}),
 	}
+	i := rl.search(from)
+	if i > 0 && rl.ranges[i-1].from == from {
+		rl.ranges[i-1] = newRange
+		return newRange
+	}
+	rl.ranges = append(rl.ranges, nil)
+	copy(rl.ranges[i+1:], rl.ranges[i:])
+	rl.ranges[i] = newRange
+	return newRange
 }
 
 // IsWithinAnyRange checks if the number is within any of the cached ranges
 func (rl *RequestLock) IsWithinAnyRange(num uint64) bool {
 	rl.mu.RLock()
 	defer rl.mu.RUnlock()
-	_, exists := rl.lookupMap[num]
-	return exists
+	return rl.lookup(num) != nil
 }
 
 // AddRange adds a new range to the cache
 func (rl *RequestLock) AddRange(from, to uint64) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
-	newRange := &Range{
-		from: from,
-		to:   to,
-		done: make(chan struct{}),
-	}
-	rl.rangeMap[from] = newRange
-	// provide fast lookup
-	for i := from; i <= to; i++ {
-		rl.lookupMap[i] = newRange
+	rl.insert(from, to)
+}
+
+// TryAddRange adds a new range to the cache unless the range is already being fetched, in which
+// case the existing range is returned with false, so the caller can wait for it to be done
+func (rl *RequestLock) TryAddRange(from, to uint64) (*Range, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if existing := rl.lookup(from); existing != nil {
+		return existing, false
 	}
+	return rl.insert(from, to), true
 }
 
 // RemoveRange removes a range from the cache
@@ -162,32 +426,54 @@ func (rl *RequestLock) RemoveRange(from, to uint64) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	r, exists := rl.rangeMap[from]
-	if !exists {
+	i := rl.search(from)
+	if i == 0 || rl.ranges[i-1].from != from {
 		return
 	}
-	delete(rl.rangeMap, from)
-	for i := from; i <= to; i++ {
-		delete(rl.lookupMap, i)
-	}
+	r := rl.ranges[i-1]
+	copy(rl.ranges[i-1:], rl.ranges[i:])
+	rl.ranges[len(rl.ranges)-1] = nil
+	rl.ranges = rl.ranges[:len(rl.ranges)-1]
 	close(r.done)
 }
 
 func (rl *RequestLock) GetRangeForNumber(number uint64) *Range {
 	rl.mu.RLock()
 	defer rl.mu.RUnlock()
-	return rl.lookupMap[number]
+	return rl.lookup(number)
 }
 
+// WaitForRange blocks until the range holding num is removed, i.e. its fetch is done, or ctx is
+// done. It returns right away if no range holds num.
+func (rl *RequestLock) WaitForRange(ctx context.Context, num uint64) error {
+	r := rl.GetRangeForNumber(num)
+	if r == nil {
+		return nil
+	}
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ErrBundleNameFormat is returned for bundle names not following blocks_s<start>_e<end>
+var ErrBundleNameFormat = errors.New("unexpected bundle name format")
+
+// ParseBundleName returns the first and last block numbers of a bundle named blocks_s<start>_e<end>
 func ParseBundleName(bundleName string) (uint64, uint64, error) {
 	parts := strings.Split(bundleName, "_")
+	if len(parts) != 3 || len(parts[1]) < 2 || len(parts[2]) < 2 {
+		return 0, 0, fmt.Errorf("%w: %q", ErrBundleNameFormat, bundleName)
+	}
 	startHeight, err := strconv.ParseUint(parts[1][1:], 10, 64)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, fmt.Errorf("%w: %q: %v", ErrBundleNameFormat, bundleName, err)
 	}
 	endHeight, err := strconv.ParseUint(parts[2][1:], 10, 64)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, fmt.Errorf("%w: %q: %v", ErrBundleNameFormat, bundleName, err)
 	}
 	return startHeight, endHeight, nil
 }
@@ -0,0 +1,103 @@
+package blockarchiver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseBundleName(t *testing.T) {
+	start, end, err := ParseBundleName("blocks_s100_e109")
+	if err != nil {
+		t.Fatalf("failed to parse bundle name: %v", err)
+	}
+	if start != 100 || end != 109 {
+		t.Errorf("range mismatch: have [%d, %d], want [100, 109]", start, end)
+	}
+	for _, name := range []string{
+		"",
+		"blocks",
+		"blocks_s100",
+		"blocks__",
+		"blocks_s_e",
+		"blocks_s100_e",
+		"blocks_sx_e109",
+		"blocks_s100_e109_extra",
+	} {
+		if _, _, err := ParseBundleName(name); !errors.Is(err, ErrBundleNameFormat) {
+			t.Errorf("bundle name %q: want %v, got %v", name, ErrBundleNameFormat, err)
+		}
+	}
+}
+
+func TestRequestLock(t *testing.T) {
+	rl := NewRequestLock()
+	rl.AddRange(100, 109)
+	rl.AddRange(0, 9)
+	if r, claimed := rl.TryAddRange(50, 59); !claimed || r == nil {
+		t.Fatal("failed to claim a free range")
+	}
+	if _, claimed := rl.TryAddRange(50, 59); claimed {
+		t.Fatal("claimed a range already being fetched")
+	}
+	for _, tt := range []struct {
+		number uint64
+		from   uint64
+		within bool
+	}{
+		{0, 0, true}, {9, 0, true}, {10, 0, false}, {49, 0, false}, {55, 50, true},
+		{99, 0, false}, {100, 100, true}, {109, 100, true}, {110, 0, false},
+	} {
+		if within := rl.IsWithinAnyRange(tt.number); within != tt.within {
+			t.Errorf("number %d: within %v, want %v", tt.number, within, tt.within)
+		}
+		if r := rl.GetRangeForNumber(tt.number); tt.within && (r == nil || r.from != tt.from) {
+			t.Errorf("number %d: range %v, want one starting at %d", tt.number, r, tt.from)
+		}
+	}
+	r := rl.GetRangeForNumber(55)
+	rl.RemoveRange(50, 59)
+	select {
+	case <-r.done:
+	default:
+		t.Error("removed range not done")
+	}
+	if rl.IsWithinAnyRange(55) || !rl.IsWithinAnyRange(5) || !rl.IsWithinAnyRange(105) {
+		t.Error("removing a range affected the other ranges")
+	}
+}
+
+func TestWaitForRange(t *testing.T) {
+	rl := NewRequestLock()
+	if err := rl.WaitForRange(context.Background(), 5); err != nil {
+		t.Fatalf("wait without a range: %v", err)
+	}
+	rl.AddRange(0, 9)
+
+	// the waiter is woken as soon as the range is removed
+	errc := make(chan error, 1)
+	go func() { errc <- rl.WaitForRange(context.Background(), 5) }()
+	rl.RemoveRange(0, 9)
+	if err := <-errc; err != nil {
+		t.Fatalf("wait for a removed range: %v", err)
+	}
+
+	// a cancelled context unblocks the waiter
+	rl.AddRange(0, 9)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rl.WaitForRange(ctx, 5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want the deadline to unblock the wait, got %v", err)
+	}
+}
+
+// BenchmarkRequestLock adds, looks up and removes a 100k block range
+func BenchmarkRequestLock(b *testing.B) {
+	rl := NewRequestLock()
+	for i := 0; i < b.N; i++ {
+		rl.AddRange(0, 99_999)
+		rl.IsWithinAnyRange(50_000)
+		rl.RemoveRange(0, 99_999)
+	}
+}
@@ -0,0 +1,92 @@
+package blockarchiver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// SegmentError is returned by VerifySegment when the verification stops before the end of the
+// segment, the blocks before Next are verified and have been reported to the progress callback
+type SegmentError struct {
+	Next uint64
+	Err  error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("segment verification stopped at block %d: %v", e.Next, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
+// VerifySegment checks that the headers served in [from, to] hash to the reported hashes and
+// link to their parents. The headers are streamed in batches, each holding a background bundle
+// fetch slot, so a long audit neither buffers the whole range nor delays the regular block fetches.
+//
+// progress, if not nil, is called after every batch with the number of verified blocks and the
+// size of the segment. The verification stops at the next batch once ctx is done, the returned
+// *SegmentError tells how far it got.
+func (c *BlockArchiverService) VerifySegment(ctx context.Context, from, to uint64, progress func(done, total uint64)) error {
+	if from > to {
+		return fmt.Errorf("invalid block range [%d, %d]", from, to)
+	}
+	if err := c.beginFetch(); err != nil {
+		return err
+	}
+	defer c.endFetch()
+	// Close stops the verification
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.shutdownCtx, cancel)
+	defer stop()
+
+	total := to - from + 1
+	var parent *types.Header
+	for start := from; ; start += headerBatchSize {
+		end := start + headerBatchSize - 1
+		if end > to || end < start {
+			end = to
+		}
+		if err := c.verifyBatch(ctx, start, end, &parent); err != nil {
+			return &SegmentError{Next: start, Err: err}
+		}
+		if progress != nil {
+			progress(end-from+1, total)
+		}
+		if end == to {
+			return nil
+		}
+	}
+}
+
+// verifyBatch verifies the headers in [start, end], parent is the last verified header which is
+// advanced to the last header of the batch
+func (c *BlockArchiverService) verifyBatch(ctx context.Context, start, end uint64, parent **types.Header) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.acquireFetchSlot(ctx, priorityBackground); err != nil {
+		return err
+	}
+	defer c.releaseFetchSlot()
+
+	blocks, err := c.client.GetHeadersByRange(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		header, err := convertVerifiedHeader(block)
+		if err != nil {
+			return err
+		}
+		if *parent != nil && header.ParentHash != (*parent).Hash() {
+			return fmt.Errorf("block %v parent hash %s doesn't match block %v hash %s",
+				header.Number, header.ParentHash, (*parent).Number, (*parent).Hash())
+		}
+		*parent = header
+	}
+	return nil
+}
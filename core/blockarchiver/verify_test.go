@@ -0,0 +1,100 @@
+package blockarchiver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newLinkedTestChain returns count wire blocks starting from block number from, each block
+// referencing the hash of the previous one
+func newLinkedTestChain(t testing.TB, from, count uint64) []*Block {
+	t.Helper()
+	txs := newTestTransactions(t)
+	blocks := make([]*Block, 0, count)
+	var parent common.Hash
+	for i := uint64(0); i < count; i++ {
+		header := newTestHeader(from+i, txs)
+		if i > 0 {
+			header.ParentHash = parent
+		}
+		parent = header.Hash()
+		blocks = append(blocks, toWireBlock(header, txs))
+	}
+	return blocks
+}
+
+func TestVerifySegment(t *testing.T) {
+	blocks := newLinkedTestChain(t, 100, 2*headerBatchSize+50)
+	service := newTestService(t, newTestArchiver(blocks...))
+	to := uint64(100 + len(blocks) - 1)
+
+	var reports [][2]uint64
+	err := service.VerifySegment(context.Background(), 100, to, func(done, total uint64) {
+		reports = append(reports, [2]uint64{done, total})
+	})
+	if err != nil {
+		t.Fatalf("failed to verify segment: %v", err)
+	}
+	total := uint64(len(blocks))
+	want := [][2]uint64{{headerBatchSize, total}, {2 * headerBatchSize, total}, {total, total}}
+	if len(reports) != len(want) {
+		t.Fatalf("want progress %v, got %v", want, reports)
+	}
+	for i := range want {
+		if reports[i] != want[i] {
+			t.Errorf("progress %d mismatch, want %v, got %v", i, want[i], reports[i])
+		}
+	}
+	if n := service.fetchSlots.inUse(); n != 0 {
+		t.Errorf("want fetch slots released, %d held", n)
+	}
+}
+
+func TestVerifySegmentBrokenLink(t *testing.T) {
+	blocks := newLinkedTestChain(t, 100, 10)
+	header, err := convertHeader(blocks[5])
+	if err != nil {
+		t.Fatalf("failed to convert header: %v", err)
+	}
+	header.ParentHash = common.Hash{0x01}
+	blocks[5] = toWireBlock(header, nil)
+	service := newTestService(t, newTestArchiver(blocks...))
+
+	var segErr *SegmentError
+	if err := service.VerifySegment(context.Background(), 100, 109, nil); !errors.As(err, &segErr) {
+		t.Fatalf("want segment error for a broken link, got %v", err)
+	}
+	if segErr.Next != 100 {
+		t.Errorf("want verification stopped at 100, got %d", segErr.Next)
+	}
+}
+
+func TestVerifySegmentCancel(t *testing.T) {
+	blocks := newLinkedTestChain(t, 100, 3*headerBatchSize)
+	archiver := newTestArchiver(blocks...)
+	service := newTestService(t, archiver)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last uint64
+	err := service.VerifySegment(ctx, 100, uint64(100+len(blocks)-1), func(done, total uint64) {
+		last = done
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want canceled verification, got %v", err)
+	}
+	var segErr *SegmentError
+	if !errors.As(err, &segErr) || segErr.Next != 100+headerBatchSize {
+		t.Fatalf("want verification stopped at %d, got %v", 100+headerBatchSize, err)
+	}
+	if last != headerBatchSize {
+		t.Errorf("want %d blocks reported, got %d", headerBatchSize, last)
+	}
+	if n := archiver.callCount("batch"); n != 1 {
+		t.Errorf("want no batch fetched after the cancellation, got %d", n)
+	}
+}
@@ -435,19 +435,16 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 	// blockCacheSize is used when init the below caches
 	// bodyCache, hc.headerCache
 	// the size of the cache is set to the same value.
-	cacheSize := int(bc.blockArchiverConfig.BlockCacheSize)
+	cacheSize := int(bc.blockArchiverConfig.CacheCapacity())
 
 	bc.hc.headerCache = lru.NewCache[common.Hash, *types.Header](cacheSize)
 	bc.bodyCache = lru.NewCache[common.Hash, *types.Body](cacheSize)
 
 	// block archiver service
 	blockArchiverService, err := blockarchiver.NewBlockArchiverService(
-		bc.blockArchiverConfig.RPCAddress,
-		bc.blockArchiverConfig.SPAddress,
-		bc.blockArchiverConfig.BucketName,
+		bc.blockArchiverConfig,
 		bc.bodyCache,
 		bc.hc.headerCache,
-		cacheSize,
 	)
 	if err != nil {
 		return nil, err
@@ -1309,6 +1306,11 @@ func (bc *BlockChain) Stop() {
 	if err := bc.triedb.Close(); err != nil {
 		log.Error("Failed to close trie database", "err", err)
 	}
+	if bc.blockArchiverService != nil {
+		if err := bc.blockArchiverService.Close(); err != nil {
+			log.Error("Failed to close block archiver service", "err", err)
+		}
+	}
 	log.Info("Blockchain stopped")
 }
 
@@ -3183,7 +3185,9 @@ func (bc *BlockChain) updateCurrentHeader() error {
 	bc.currentBlock.Store(header)
 	headBlockGauge.Update(int64(header.Number.Uint64()))
 
-	bc.hc.tdCache.Add(block.Hash(), block.TotalDifficulty)
+	if block.TotalDifficulty != nil {
+		bc.hc.tdCache.Add(block.Hash(), block.TotalDifficulty)
+	}
 	log.Info("update current header", "number", header.Number, "hash", header.Hash())
 	return nil
 }
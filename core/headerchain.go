@@ -135,7 +135,7 @@ func (hc *HeaderChain) GetBlockNumber(hash common.Hash) *uint64 {
 	if cached, ok := hc.numberCache.Get(hash); ok {
 		return &cached
 	}
-	_, header, _ := hc.blockArchiverService.GetBlockByHash(hash)
+	header, _ := hc.blockArchiverService.GetHeaderByHash(hash)
 	if header == nil {
 		return nil
 	}
@@ -512,7 +512,7 @@ func (hc *HeaderChain) GetHeader(hash common.Hash, number uint64) *types.Header
 	}
 
 	// get header from block archiver, and the headerCache will be updated there
-	_, header, _ := hc.blockArchiverService.GetBlockByNumber(number)
+	header, _ := hc.blockArchiverService.GetHeaderByNumber(number)
 	return header
 }
 